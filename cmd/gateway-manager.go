@@ -2,15 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jademperor/common/pkg/ginutils"
 	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/alerting"
 	"github.com/jademperor/gateway-manager/internal/controllers"
 	"github.com/jademperor/gateway-manager/internal/healthchecking"
 	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
 	"github.com/jademperor/gateway-manager/internal/services"
 )
 
@@ -21,6 +26,12 @@ var (
 	addr    = flag.String("addr", ":8999", "the addr http api server listen and serve on, default = 8999")
 	debug   = flag.Bool("debug", false, "set debug mode on, default not open debug mode (false)")
 	logpath = flag.String("logpath", "./logs", "the folder directory what log files would be stored at")
+	id      = flag.String("id", "", "the unique id of this replica, default = {hostname}-{pid}")
+
+	replicaTTL        = flag.Duration("replica-ttl", 10*time.Second, "the health checks of a dead replica and the leader are taken over within this duration")
+	reconcileInterval = flag.Duration("reconcile-interval", time.Minute, "the interval to reconcile health checks with the stored instances")
+	alertInterval     = flag.Duration("alert-interval", 10*time.Second, "the interval the leader evaluates the alerting rules")
+	secretKeyFile     = flag.String("secret-key-file", "", "the file of the key to seal health check credentials, it must be the same on all replicas")
 )
 
 func prepare() {
@@ -40,23 +51,64 @@ func prepare() {
 	engine.DELETE("/v1/clusters/:clusterID", controllers.DelCluster)
 	engine.PUT("/v1/clusters/:clusterID", controllers.UpdateClusterInfo)
 	engine.GET("/v1/clusters/:clusterID", controllers.GetClusterInfo)
-
+	engine.POST("/v1/clusters/:clusterID/clone", controllers.CloneCluster)
+	engine.PUT("/v1/clusters/:clusterID/health_check", controllers.SetClusterHealthCheck)
+	engine.PUT("/v1/clusters/:clusterID/health_pause", controllers.PauseClusterHealth)
+	engine.PUT("/v1/clusters/:clusterID/min_healthy", controllers.SetClusterMinHealthy)
+	engine.DELETE("/v1/clusters/:clusterID/health_pause", controllers.ResumeClusterHealth)
+	engine.PUT("/v1/clusters/:clusterID/health_shadow", controllers.SetClusterHealthShadow)
+	engine.DELETE("/v1/clusters/:clusterID/health_shadow", controllers.DelClusterHealthShadow)
+
+	engine.PUT("/v1/clusters/:clusterID/instances", controllers.ReplaceClusterInstances)
 	engine.POST("/v1/clusters/:clusterID/instance", controllers.AddClusterInstance)
 	engine.DELETE("/v1/clusters/:clusterID/instance/:instanceID", controllers.DelClusterInstance)
 	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID", controllers.UpdateClusterInstance)
 	engine.GET("/v1/clusters/:clusterID/instance/:instanceID", controllers.GetClusterInstance)
+	engine.GET("/v1/clusters/:clusterID/instance/:instanceID/health", controllers.GetClusterInstanceHealth)
+	engine.POST("/v1/clusters/:clusterID/instance/:instanceID/check", controllers.CheckClusterInstance)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/drain", controllers.DrainClusterInstance)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/disable", controllers.DisableClusterInstance)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/enable", controllers.EnableClusterInstance)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/health_check", controllers.SetClusterInstanceHealthCheck)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/health_override", controllers.SetClusterInstanceHealthOverride)
+	engine.DELETE("/v1/clusters/:clusterID/instance/:instanceID/health_override", controllers.DelClusterInstanceHealthOverride)
+	engine.PUT("/v1/clusters/:clusterID/instance/:instanceID/health_pause", controllers.PauseClusterInstanceHealth)
+	engine.DELETE("/v1/clusters/:clusterID/instance/:instanceID/health_pause", controllers.ResumeClusterInstanceHealth)
 
 	engine.GET("/v1/apis", controllers.GetAllAPIs)
 	engine.POST("/v1/apis/api", controllers.AddAPI)
 	engine.DELETE("/v1/apis/:apiID", controllers.DelAPI)
 	engine.PUT("/v1/apis/:apiID", controllers.UpdateAPI)
 	engine.GET("/v1/apis/:apiID", controllers.GetAPIInfo)
+	// "/v1/apis/:apiID/clone" conflicts with "/v1/apis/api" in router
+	engine.POST("/v1/apis/clone/:apiID", controllers.CloneAPI)
 
 	engine.GET("/v1/routings", controllers.GetAllRoutings)
 	engine.POST("/v1/routings/routing", controllers.AddRouting)
 	engine.DELETE("/v1/routings/:routingID", controllers.DelRouting)
 	engine.PUT("/v1/routings/:routingID", controllers.UpdateRouting)
 	engine.GET("/v1/routings/:routingID", controllers.GetRoutingInfo)
+	// "/v1/routings/:routingID/clone" conflicts with "/v1/routings/routing" in router
+	engine.POST("/v1/routings/clone/:routingID", controllers.CloneRouting)
+
+	engine.GET("/v1/healthchecking/leader", controllers.GetHealthCheckingLeader)
+	engine.GET("/v1/healthchecking/replicas", controllers.GetHealthCheckingReplicas)
+	engine.GET("/v1/healthchecking/jobs", controllers.GetHealthCheckingJobs)
+	engine.POST("/v1/healthchecking/reports", controllers.ReportPassiveHealth)
+	engine.PUT("/v1/healthchecking/shadow", controllers.SetGlobalHealthShadow)
+	engine.DELETE("/v1/healthchecking/shadow", controllers.DelGlobalHealthShadow)
+	engine.GET("/v1/healthchecking/shadow/report", controllers.GetHealthShadowReport)
+
+	engine.GET("/v1/synthetics", controllers.GetAllSynthetics)
+	engine.POST("/v1/synthetics/synthetic", controllers.AddSynthetic)
+	engine.GET("/v1/synthetics/:probeID", controllers.GetSynthetic)
+	engine.PUT("/v1/synthetics/:probeID", controllers.UpdateSynthetic)
+	engine.DELETE("/v1/synthetics/:probeID", controllers.DelSynthetic)
+
+	engine.GET("/v1/alerting/config", controllers.GetAlertingConfig)
+	engine.PUT("/v1/alerting/config", controllers.SetAlertingConfig)
+	engine.GET("/v1/alerting/alerts", controllers.GetAlerts)
+	engine.POST("/v1/alerting/channels/:name/test", controllers.TestAlertingChannel)
 
 	// engine.GET("/v1/plugins", controllers.GetAllPlugins)
 	// engine.PUT("/v1/plugins/:id/status", controllers.UpdatePluginsStatus)
@@ -79,6 +131,9 @@ func main() {
 	if err := logger.Init(*logpath); err != nil {
 		log.Fatal(err)
 	}
+	if err := secrets.Init(*secretKeyFile); err != nil {
+		log.Fatal(err)
+	}
 	if err := services.Init(etcdAddrs); err != nil {
 		log.Fatal(err)
 	}
@@ -89,7 +144,13 @@ func main() {
 	}
 
 	// start health checker ....
-	healthchecking.Init(etcdAddrs, 1*time.Second)
+	if *id == "" {
+		hostname, _ := os.Hostname()
+		*id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	self := &models.Manager{ID: *id, Addr: *addr, StartedAt: time.Now()}
+	healthchecking.Init(etcdAddrs, *reconcileInterval, self, *replicaTTL)
+	alerting.Init(*alertInterval, healthchecking.IsLeader)
 
 	// start the server
 	prepare()
@@ -8,5 +8,6 @@ require (
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	github.com/stretchr/testify v1.3.0 // indirect
 	go.etcd.io/etcd v3.3.12+incompatible
+	google.golang.org/grpc v1.18.0
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 )
@@ -0,0 +1,390 @@
+// Package alerting notifies the health of instances, clusters and synthetic
+// probes by the rules in the alerting config. only the leader replica
+// evaluates the rules, and the alerts are saved, so each alert is notified
+// once while firing and once while resolved, even across leader changes.
+// the notifications failed to be delivered are sent again by the next
+// evaluations until they're delivered.
+package alerting
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+)
+
+// Store is where the engine loads the config and health from,
+// and saves the alerts to
+type Store interface {
+	Config() (*models.AlertingConfig, error)
+	Clusters() ([]*services.Cluster, error)
+	Synthetics() ([]*services.Synthetic, error)
+	Alerts() (map[string]*models.Alert, error)
+	SaveAlerts(alerts map[string]*models.Alert) error
+}
+
+// servicesStore is the Store on the services layer
+type servicesStore struct{}
+
+func (servicesStore) Config() (*models.AlertingConfig, error) {
+	return services.GetAlertingConfig()
+}
+
+func (servicesStore) Clusters() ([]*services.Cluster, error) {
+	return services.GetAllClusters("")
+}
+
+func (servicesStore) Synthetics() ([]*services.Synthetic, error) {
+	return services.GetAllSynthetics()
+}
+
+func (servicesStore) Alerts() (map[string]*models.Alert, error) {
+	return services.GetAlerts()
+}
+
+func (servicesStore) SaveAlerts(alerts map[string]*models.Alert) error {
+	return services.SetAlerts(alerts)
+}
+
+// Engine evaluates the rules and notifies the alerts
+type Engine struct {
+	store  Store
+	notify func(ch *models.AlertChannel, n *Notification) error
+	alerts map[string]*models.Alert // by fingerprint, nil until loaded
+	quit   chan struct{}
+
+	sending  sync.WaitGroup   // notifications in flight
+	inflight map[string]bool  // fingerprint|channel of the notifications in flight
+	mutex    sync.Mutex       // protect results
+	results  []deliveryResult // results of the notifications sent, applied by Eval
+}
+
+// deliveryResult is the result of sending a notification of an alert
+type deliveryResult struct {
+	fp       string
+	channel  string
+	lastSent time.Time // LastSent of the alert the notification is of
+	err      error
+}
+
+// New create an engine on store
+func New(store Store) *Engine {
+	return &Engine{
+		store:    store,
+		notify:   Notify,
+		quit:     make(chan struct{}),
+		inflight: make(map[string]bool),
+	}
+}
+
+// Init start evaluating the rules every interval while this replica is the leader
+func Init(interval time.Duration, isLeader func() bool) {
+	go New(servicesStore{}).Run(interval, isLeader)
+}
+
+// Run evaluate the rules every interval while isLeader, until Stop
+func (e *Engine) Run(interval time.Duration, isLeader func() bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		case now := <-ticker.C:
+			if !isLeader() {
+				// reload the alerts saved by the other leader while leading again
+				e.alerts = nil
+				e.collect()
+				continue
+			}
+			e.Eval(now)
+		}
+	}
+}
+
+// Stop the engine
+func (e *Engine) Stop() {
+	close(e.quit)
+}
+
+// condition is a target matching a rule at now
+type condition struct {
+	rule    *models.AlertRule
+	target  string
+	summary string
+	since   time.Time // the condition began, zero if unknown
+}
+
+func fingerprint(ruleID, target string) string {
+	return ruleID + "|" + target
+}
+
+// Eval evaluate the rules at now. an alert fires while its condition lasts
+// longer than the rule's For, and it's notified again every RepeatInterval
+// if set. the firing alerts are resolved while the condition is gone.
+// the latest notification of each alert is sent again to the channels it
+// has not been delivered to.
+func (e *Engine) Eval(now time.Time) {
+	cfg, err := e.store.Config()
+	if err != nil {
+		logger.Logger.Errorf("alerting load config got err: %v", err)
+		return
+	}
+	clusters, err := e.store.Clusters()
+	if err != nil {
+		logger.Logger.Errorf("alerting load clusters got err: %v", err)
+		return
+	}
+	var synthetics []*services.Synthetic
+	if cfg.HasKind(models.AlertSyntheticFailing) {
+		if synthetics, err = e.store.Synthetics(); err != nil {
+			logger.Logger.Errorf("alerting load synthetic probes got err: %v", err)
+			return
+		}
+	}
+	if e.alerts == nil {
+		if e.alerts, err = e.store.Alerts(); err != nil {
+			logger.Logger.Errorf("alerting load alerts got err: %v", err)
+			e.alerts = nil
+			return
+		}
+	}
+
+	changed := e.collect()
+
+	conds := make(map[string]*condition)
+	for _, rule := range cfg.Rules {
+		var matched []*condition
+		if rule.Kind == models.AlertSyntheticFailing {
+			matched = matchSynthetics(rule, synthetics)
+		} else {
+			matched = match(rule, clusters)
+		}
+		for _, cond := range matched {
+			conds[fingerprint(rule.ID, cond.target)] = cond
+		}
+	}
+
+	for fp, cond := range conds {
+		alert, ok := e.alerts[fp]
+		// the condition is back while the resolved one is being notified
+		if !ok || alert.EndsAt != nil {
+			since := cond.since
+			if since.IsZero() || since.After(now) {
+				since = now
+			}
+			alert = &models.Alert{RuleID: cond.rule.ID, Kind: cond.rule.Kind, Target: cond.target, StartsAt: since}
+			e.alerts[fp] = alert
+			changed = true
+		}
+		alert.Summary = cond.summary
+
+		repeat := cond.rule.RepeatInterval.Std()
+		switch {
+		case !alert.Firing && now.Sub(alert.StartsAt) >= cond.rule.For.Std():
+			alert.Firing = true
+		case alert.Firing && repeat > 0 && now.Sub(alert.LastSent) >= repeat:
+			// notify the firing alert again
+		default:
+			continue
+		}
+		e.send(cfg, cond.rule, fp, alert, now)
+		changed = true
+	}
+
+	for fp, alert := range e.alerts {
+		if _, ok := conds[fp]; ok || alert.EndsAt != nil {
+			continue
+		}
+		changed = true
+		rule := ruleOf(cfg, alert.RuleID)
+		if !alert.Firing || rule == nil {
+			if alert.Firing {
+				logger.Logger.Infof("alerting drop alert %s, its rule has been deleted", fp)
+			}
+			delete(e.alerts, fp)
+			continue
+		}
+		ends := now
+		alert.EndsAt = &ends
+		e.send(cfg, rule, fp, alert, now)
+	}
+
+	// retry the undelivered notifications, and drop the resolved alerts
+	// delivered to all the channels
+	for fp, alert := range e.alerts {
+		rule := ruleOf(cfg, alert.RuleID)
+		switch {
+		case alert.EndsAt != nil && (rule == nil || !alert.Undelivered()):
+			delete(e.alerts, fp)
+			changed = true
+		case rule != nil && alert.Undelivered():
+			changed = e.deliver(cfg, fp, alert) || changed
+		}
+	}
+
+	if changed {
+		if err := e.store.SaveAlerts(e.alerts); err != nil {
+			logger.Logger.Errorf("alerting save alerts got err: %v", err)
+		}
+	}
+}
+
+// send a new notification of alert to the channels of rule
+func (e *Engine) send(cfg *models.AlertingConfig, rule *models.AlertRule, fp string, alert *models.Alert, now time.Time) {
+	alert.LastSent = now
+	alert.Delivered = make(map[string]bool, len(rule.Channels))
+	for _, name := range rule.Channels {
+		alert.Delivered[name] = false
+	}
+	e.deliver(cfg, fp, alert)
+}
+
+// deliver the latest notification of alert to the channels it has not been
+// delivered to, except the ones in flight. each channel is notified on its
+// own goroutine, so a slow channel never holds the evaluation or the other
+// channels, the results are applied by collect. the unknown channels are
+// dropped, returns whether any is dropped
+func (e *Engine) deliver(cfg *models.AlertingConfig, fp string, alert *models.Alert) bool {
+	n := newNotification(alert)
+	dropped := false
+	for name, delivered := range alert.Delivered {
+		key := fp + "|" + name
+		if delivered || e.inflight[key] {
+			continue
+		}
+		ch := cfg.Channel(name)
+		if ch == nil {
+			logger.Logger.Errorf("alerting rule %s notify unknown channel %s", alert.RuleID, name)
+			delete(alert.Delivered, name)
+			dropped = true
+			continue
+		}
+
+		e.inflight[key] = true
+		e.sending.Add(1)
+		go func(ch *models.AlertChannel, result deliveryResult) {
+			defer e.sending.Done()
+			if result.err = e.notify(ch, n); result.err != nil {
+				logger.Logger.Errorf("alerting notify %s of alert [%s] got err: %v", ch.Name, n.Summary, result.err)
+			}
+			e.mutex.Lock()
+			e.results = append(e.results, result)
+			e.mutex.Unlock()
+		}(ch, deliveryResult{fp: fp, channel: name, lastSent: alert.LastSent})
+	}
+	return dropped
+}
+
+// collect apply the results of the notifications sent, the ones of an
+// older notification than the latest of the alert are ignored. returns
+// whether any alert is changed
+func (e *Engine) collect() bool {
+	e.mutex.Lock()
+	results := e.results
+	e.results = nil
+	e.mutex.Unlock()
+
+	changed := false
+	for _, result := range results {
+		delete(e.inflight, result.fp+"|"+result.channel)
+		alert := e.alerts[result.fp]
+		if result.err != nil || alert == nil || !alert.LastSent.Equal(result.lastSent) {
+			continue
+		}
+		if _, ok := alert.Delivered[result.channel]; ok {
+			alert.Delivered[result.channel] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func ruleOf(cfg *models.AlertingConfig, id string) *models.AlertRule {
+	for _, rule := range cfg.Rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+// match returns the conditions of rule in clusters. instances out of
+// rotation, not checked or with an active health override never match
+func match(rule *models.AlertRule, clusters []*services.Cluster) []*condition {
+	var conds []*condition
+	for _, cls := range clusters {
+		if rule.ClusterID != "" && rule.ClusterID != cls.Idx {
+			continue
+		}
+
+		if rule.Kind == models.AlertClusterDegraded {
+			if health := cls.Health; health.Status != models.ClusterStatusOK {
+				conds = append(conds, &condition{
+					rule:   rule,
+					target: cls.Idx,
+					summary: fmt.Sprintf("cluster %s(%s) is %s: %d/%d instances alive, min_healthy %d%%",
+						cls.Name, cls.Idx, health.Status, health.Alive, health.Total, health.MinHealthy),
+				})
+			}
+			continue
+		}
+
+		for _, ins := range cls.Instances {
+			if !ins.NeedCheckHealth || ins.Health == nil || ins.Overridden ||
+				ins.GetAdminState() != models.AdminStateEnabled {
+				continue
+			}
+			target := cls.Idx + "/" + ins.Idx
+			switch {
+			case rule.Kind == models.AlertInstanceDown && !ins.IsAlive:
+				since := ins.Health.Since
+				if since.IsZero() {
+					since = ins.Health.LastCheckTime
+				}
+				conds = append(conds, &condition{
+					rule:    rule,
+					target:  target,
+					since:   since,
+					summary: fmt.Sprintf("instance %s(%s) of cluster %s is down: %s", ins.Name, ins.Addr, cls.Name, ins.Health.LastError),
+				})
+			case rule.Kind == models.AlertFlapping && ins.Health.Flapping:
+				conds = append(conds, &condition{
+					rule:    rule,
+					target:  target,
+					summary: fmt.Sprintf("instance %s(%s) of cluster %s is flapping", ins.Name, ins.Addr, cls.Name),
+				})
+			}
+		}
+	}
+	return conds
+}
+
+// matchSynthetics returns the conditions of rule in the synthetic probes.
+// probes not checked yet or whose target is not found never match
+func matchSynthetics(rule *models.AlertRule, synthetics []*services.Synthetic) []*condition {
+	var conds []*condition
+	for _, synthetic := range synthetics {
+		if rule.ProbeID != "" && rule.ProbeID != synthetic.Idx {
+			continue
+		}
+		if synthetic.Health == nil || synthetic.TargetError != "" || synthetic.Health.IsAlive {
+			continue
+		}
+		since := synthetic.Health.Since
+		if since.IsZero() {
+			since = synthetic.Health.LastCheckTime
+		}
+		conds = append(conds, &condition{
+			rule:    rule,
+			target:  models.SyntheticKey(synthetic.Idx),
+			since:   since,
+			summary: fmt.Sprintf("synthetic probe %s(%s) is failing: %s", synthetic.Name, synthetic.TargetURL, synthetic.Health.LastError),
+		})
+	}
+	return conds
+}
@@ -0,0 +1,294 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Init(os.TempDir()); err != nil {
+		panic(err)
+	}
+	logger.Logger.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
+
+type memStore struct {
+	cfg        *models.AlertingConfig
+	clusters   []*services.Cluster
+	synthetics []*services.Synthetic
+	alerts     map[string]*models.Alert
+}
+
+func (s *memStore) Config() (*models.AlertingConfig, error)    { return s.cfg, nil }
+func (s *memStore) Clusters() ([]*services.Cluster, error)     { return s.clusters, nil }
+func (s *memStore) Synthetics() ([]*services.Synthetic, error) { return s.synthetics, nil }
+func (s *memStore) Alerts() (map[string]*models.Alert, error) {
+	alerts := make(map[string]*models.Alert, len(s.alerts))
+	for fp, alert := range s.alerts {
+		copied := *alert
+		alerts[fp] = &copied
+	}
+	return alerts, nil
+}
+func (s *memStore) SaveAlerts(alerts map[string]*models.Alert) error {
+	s.alerts, _ = (&memStore{alerts: alerts}).Alerts()
+	return nil
+}
+
+func newTestCluster(isAlive bool, since time.Time) *services.Cluster {
+	ins := &models.ServerInstance{
+		ServerInstance: cmodels.ServerInstance{Idx: "i1", Name: "a", Addr: "127.0.0.1:8080", NeedCheckHealth: true, IsAlive: isAlive},
+		AdminState:     models.AdminStateEnabled,
+	}
+	instances := []*services.Instance{{
+		ServerInstance: ins,
+		Health:         &models.HealthStatus{IsAlive: isAlive, Since: since},
+		Routable:       isAlive,
+	}}
+	status := models.ClusterStatusOK
+	if !isAlive {
+		status = models.ClusterStatusDown
+	}
+	return &services.Cluster{
+		Idx:       "c1",
+		Name:      "cls",
+		Health:    &services.ClusterHealth{Total: 1, Status: status},
+		Instances: instances,
+	}
+}
+
+// eval evaluate the rules at now, and again once the notifications are
+// sent so their results are saved and the undelivered ones retried
+func eval(e *Engine, now time.Time) {
+	for i := 0; i < 2; i++ {
+		e.Eval(now)
+		e.sending.Wait()
+	}
+}
+
+func Test_EngineEval(t *testing.T) {
+	now := time.Now()
+	store := &memStore{cfg: &models.AlertingConfig{
+		Rules: []*models.AlertRule{
+			{ID: "down", Kind: models.AlertInstanceDown, For: models.Duration(time.Minute), Channels: []string{"hook"}},
+			{ID: "degraded", Kind: models.AlertClusterDegraded, For: models.Duration(time.Minute), Channels: []string{"hook"}},
+		},
+		Channels: []*models.AlertChannel{{Name: "hook", Type: models.ChannelWebhook}},
+	}}
+	var (
+		sent  []*Notification
+		mutex sync.Mutex
+	)
+	e := New(store)
+	e.notify = func(ch *models.AlertChannel, n *Notification) error {
+		mutex.Lock()
+		sent = append(sent, n)
+		mutex.Unlock()
+		return nil
+	}
+
+	// the instance has been down for 2m, the cluster is seen down just now
+	store.clusters = []*services.Cluster{newTestCluster(false, now.Add(-2*time.Minute))}
+	eval(e, now)
+	if len(sent) != 1 || sent[0].RuleID != "down" || sent[0].Status != StatusFiring {
+		t.Fatalf("want instance down fired only, got: %+v", sent)
+	}
+	if len(store.alerts) != 2 {
+		t.Fatalf("want 2 alerts saved, got: %d", len(store.alerts))
+	}
+
+	// a new leader loads the alerts saved, nothing is notified again
+	e = New(store)
+	e.notify = func(ch *models.AlertChannel, n *Notification) error {
+		mutex.Lock()
+		sent = append(sent, n)
+		mutex.Unlock()
+		return nil
+	}
+	eval(e, now.Add(30*time.Second))
+	if len(sent) != 1 {
+		t.Fatalf("want no repeats, got: %+v", sent[1:])
+	}
+	eval(e, now.Add(time.Minute))
+	if len(sent) != 2 || sent[1].RuleID != "degraded" {
+		t.Fatalf("want cluster degraded fired after 1m, got: %+v", sent[1:])
+	}
+
+	store.clusters = []*services.Cluster{newTestCluster(true, now)}
+	eval(e, now.Add(2*time.Minute))
+	if len(sent) != 4 || sent[2].Status != StatusResolved || sent[3].Status != StatusResolved {
+		t.Fatalf("want both resolved, got: %+v", sent[2:])
+	}
+	if len(store.alerts) != 0 {
+		t.Errorf("want no alerts left, got: %d", len(store.alerts))
+	}
+}
+
+func Test_EngineEvalSynthetic(t *testing.T) {
+	now := time.Now()
+	store := &memStore{cfg: &models.AlertingConfig{
+		Rules: []*models.AlertRule{
+			{ID: "synthetic", Kind: models.AlertSyntheticFailing, ProbeID: "p1", Channels: []string{"hook"}},
+		},
+		Channels: []*models.AlertChannel{{Name: "hook", Type: models.ChannelWebhook}},
+	}}
+	var (
+		sent  []*Notification
+		mutex sync.Mutex
+	)
+	e := New(store)
+	e.notify = func(ch *models.AlertChannel, n *Notification) error {
+		mutex.Lock()
+		sent = append(sent, n)
+		mutex.Unlock()
+		return nil
+	}
+
+	failing := func(probeID string) *services.Synthetic {
+		return &services.Synthetic{
+			SyntheticProbe: &models.SyntheticProbe{Idx: probeID, Name: probeID},
+			Health:         &models.HealthStatus{IsAlive: false, Since: now, LastError: "status 502"},
+		}
+	}
+	store.synthetics = []*services.Synthetic{failing("p1"), failing("p2")}
+	eval(e, now)
+	if len(sent) != 1 || sent[0].RuleID != "synthetic" || sent[0].Status != StatusFiring {
+		t.Fatalf("want the synthetic probe p1 fired only, got: %+v", sent)
+	}
+	if _, ok := store.alerts[fingerprint("synthetic", models.SyntheticKey("p1"))]; !ok {
+		t.Fatalf("want the alert of p1 saved, got: %+v", store.alerts)
+	}
+
+	store.synthetics[0].Health = &models.HealthStatus{IsAlive: true}
+	eval(e, now.Add(time.Minute))
+	if len(sent) != 2 || sent[1].Status != StatusResolved {
+		t.Fatalf("want the synthetic probe resolved, got: %+v", sent[1:])
+	}
+}
+
+func Test_EngineRetry(t *testing.T) {
+	now := time.Now()
+	store := &memStore{cfg: &models.AlertingConfig{
+		Rules: []*models.AlertRule{
+			{ID: "down", Kind: models.AlertInstanceDown, Channels: []string{"hook", "mail"}},
+		},
+		Channels: []*models.AlertChannel{
+			{Name: "hook", Type: models.ChannelWebhook},
+			{Name: "mail", Type: models.ChannelSMTP},
+		},
+	}}
+	var (
+		sent   = make(map[string]int) // channel to count of notifications sent
+		hookUp bool
+		mutex  sync.Mutex
+	)
+	e := New(store)
+	e.notify = func(ch *models.AlertChannel, n *Notification) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent[ch.Name+" "+n.Status]++
+		if ch.Name == "hook" && !hookUp {
+			return errors.New("webhook is down")
+		}
+		return nil
+	}
+
+	store.clusters = []*services.Cluster{newTestCluster(false, now)}
+	eval(e, now)
+	if sent["hook firing"] != 2 || sent["mail firing"] != 1 {
+		t.Fatalf("want the failed webhook retried only, got: %v", sent)
+	}
+	alert := store.alerts[fingerprint("down", "c1/i1")]
+	if alert == nil || alert.Delivered["hook"] || !alert.Delivered["mail"] {
+		t.Fatalf("want the undelivered channel saved, got: %+v", alert)
+	}
+
+	hookUp = true
+	eval(e, now.Add(time.Minute))
+	eval(e, now.Add(2*time.Minute))
+	if sent["hook firing"] != 3 || sent["mail firing"] != 1 {
+		t.Fatalf("want the webhook delivered once it's up, got: %v", sent)
+	}
+
+	// the resolved alert is kept until it's delivered
+	hookUp = false
+	store.clusters = []*services.Cluster{newTestCluster(true, now)}
+	eval(e, now.Add(3*time.Minute))
+	if len(store.alerts) != 1 || sent["mail resolved"] != 1 {
+		t.Fatalf("want the resolved alert kept, got: %v %v", store.alerts, sent)
+	}
+	hookUp = true
+	eval(e, now.Add(4*time.Minute))
+	if len(store.alerts) != 0 || sent["hook resolved"] != 3 || sent["mail resolved"] != 1 {
+		t.Fatalf("want the resolved alert delivered and dropped, got: %v %v", store.alerts, sent)
+	}
+}
+
+func Test_NotifyWebhook(t *testing.T) {
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	ch := &models.AlertChannel{Name: "hook", Type: models.ChannelWebhook, Webhook: &models.WebhookChannel{URL: srv.URL}}
+	if err := TestFire(ch); err == nil {
+		t.Errorf("want err without the token header")
+	}
+	ch.Webhook.Headers = map[string]string{"X-Token": "secret"}
+	if err := TestFire(ch); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Test || got.Status != StatusFiring || got.Target != "hook" {
+		t.Errorf("want test notification, got: %+v", got)
+	}
+}
+
+func Test_NotifySMTPTimeout(t *testing.T) {
+	// the server accepts but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	defer func(timeout time.Duration) { smtpTimeout = timeout }(smtpTimeout)
+	smtpTimeout = 100 * time.Millisecond
+	ch := &models.AlertChannel{Name: "mail", Type: models.ChannelSMTP, SMTP: &models.SMTPChannel{
+		Addr: ln.Addr().String(), From: "a@example.com", To: []string{"b@example.com"},
+	}}
+	start := time.Now()
+	if err := TestFire(ch); err == nil {
+		t.Fatalf("want err from the silent server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("want the mail given up within the timeout, took: %s", elapsed)
+	}
+}
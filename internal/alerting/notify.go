@@ -0,0 +1,184 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
+)
+
+// webhookTimeout is the timeout of posting a notification
+const webhookTimeout = 10 * time.Second
+
+// smtpTimeout is the timeout of sending a mail, dialing included
+var smtpTimeout = 30 * time.Second
+
+const (
+	// StatusFiring the alert fires
+	StatusFiring = "firing"
+	// StatusResolved the alert has been resolved
+	StatusResolved = "resolved"
+)
+
+// Notification is sent to the channels while an alert fires or resolves,
+// it's the JSON body of webhooks
+type Notification struct {
+	Status   string           `json:"status"` // firing or resolved
+	RuleID   string           `json:"rule_id"`
+	Kind     models.AlertKind `json:"kind"`
+	Target   string           `json:"target"` // clusterID/instanceID or clusterID
+	Summary  string           `json:"summary"`
+	StartsAt time.Time        `json:"starts_at"`
+	EndsAt   *time.Time       `json:"ends_at,omitempty"` // resolved alerts only
+	Test     bool             `json:"test,omitempty"`    // sent by the test-fire api
+}
+
+// newNotification returns the notification of alert, it's resolved
+// while alert.EndsAt is set
+func newNotification(alert *models.Alert) *Notification {
+	n := &Notification{
+		Status:   StatusFiring,
+		RuleID:   alert.RuleID,
+		Kind:     alert.Kind,
+		Target:   alert.Target,
+		Summary:  alert.Summary,
+		StartsAt: alert.StartsAt,
+	}
+	if alert.EndsAt != nil {
+		n.Status = StatusResolved
+		n.EndsAt = alert.EndsAt
+	}
+	return n
+}
+
+// TestFire send a test notification to the channel
+func TestFire(ch *models.AlertChannel) error {
+	return Notify(ch, &Notification{
+		Status:   StatusFiring,
+		RuleID:   "test",
+		Target:   ch.Name,
+		Summary:  fmt.Sprintf("test notification of channel %s", ch.Name),
+		StartsAt: time.Now(),
+		Test:     true,
+	})
+}
+
+// Notify send the notification to the channel, the sealed secrets of
+// the channel are opened
+func Notify(ch *models.AlertChannel, n *Notification) error {
+	ch, err := secrets.OpenChannel(ch)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ch.Type == models.ChannelWebhook && ch.Webhook != nil:
+		return postWebhook(ch.Webhook, n)
+	case ch.Type == models.ChannelSMTP && ch.SMTP != nil:
+		return sendMail(ch.SMTP, n)
+	}
+	return fmt.Errorf("channel %s has no %s config", ch.Name, ch.Type)
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+func postWebhook(hook *models.WebhookChannel, n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendMail send the notification like smtp.SendMail, within smtpTimeout
+// so an unresponsive server never blocks the sender
+func sendMail(cfg *models.SMTPChannel, n *Notification) error {
+	conn, err := net.DialTimeout("tcp", cfg.Addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(cfg.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(mailOf(cfg, n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// mailOf format the notification as a plain text mail
+func mailOf(cfg *models.SMTPChannel, n *Notification) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(&buf, "Subject: [%s] %s\r\n", strings.ToUpper(n.Status), n.Summary)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&buf, "%s\r\n\r\n", n.Summary)
+	fmt.Fprintf(&buf, "status: %s\r\n", n.Status)
+	fmt.Fprintf(&buf, "rule: %s (%s)\r\n", n.RuleID, n.Kind)
+	fmt.Fprintf(&buf, "target: %s\r\n", n.Target)
+	fmt.Fprintf(&buf, "starts at: %s\r\n", n.StartsAt.Format(time.RFC3339))
+	if n.EndsAt != nil {
+		fmt.Fprintf(&buf, "ends at: %s\r\n", n.EndsAt.Format(time.RFC3339))
+	}
+	return buf.Bytes()
+}
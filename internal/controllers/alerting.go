@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/alerting"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+	"github.com/jademperor/gateway-manager/internal/validate"
+)
+
+type getAlertingConfigResp struct {
+	code.CodeInfo
+	Config *models.AlertingConfig `json:"config,omitempty"`
+}
+
+// GetAlertingConfig get the alerting rules and channels, the secrets of
+// the channels are not echoed back
+func GetAlertingConfig(c *gin.Context) {
+	var (
+		resp = new(getAlertingConfigResp)
+	)
+
+	cfg, err := services.GetAlertingConfig()
+	if err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Config = cfg.Redacted()
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type setAlertingConfigResp struct {
+	code.CodeInfo
+	fieldErrors
+}
+
+// SetAlertingConfig replace the alerting rules and channels
+func SetAlertingConfig(c *gin.Context) {
+	var (
+		cfg  = new(models.AlertingConfig)
+		resp = new(setAlertingConfigResp)
+	)
+
+	if err := c.ShouldBindJSON(cfg); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.AlertingConfig(cfg)) {
+		return
+	}
+
+	if err := services.SetAlertingConfig(cfg); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getAlertsResp struct {
+	code.CodeInfo
+	Alerts map[string]*models.Alert `json:"alerts"`
+}
+
+// GetAlerts get the alerts pending or firing
+func GetAlerts(c *gin.Context) {
+	var (
+		resp = new(getAlertsResp)
+		err  error
+	)
+
+	if resp.Alerts, err = services.GetAlerts(); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type testAlertingChannelResp struct {
+	code.CodeInfo
+}
+
+// TestAlertingChannel send a test notification to the channel
+func TestAlertingChannel(c *gin.Context) {
+	var (
+		resp = new(testAlertingChannelResp)
+	)
+
+	cfg, err := services.GetAlertingConfig()
+	if err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	name := c.Param("name")
+	ch := cfg.Channel(name)
+	if ch == nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeParamInvalid, "unknown channel: "+name))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if err := alerting.TestFire(ch); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
@@ -2,12 +2,14 @@ package controllers
 
 import (
 	"github.com/jademperor/gateway-manager/internal/services"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jademperor/common/models"
 	"github.com/jademperor/common/pkg/code"
 	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/validate"
 )
 
 type getAllAPIsForm struct {
@@ -63,6 +65,7 @@ type apiCombination struct {
 
 type addAPIResp struct {
 	code.CodeInfo
+	fieldErrors
 	APIID string `json:"api_id,omitempty"`
 }
 
@@ -100,6 +103,10 @@ func AddAPI(c *gin.Context) {
 		CombineReqCfgs:  combCfgs,
 	}
 
+	if abortWithFieldErrors(c, resp, validate.API(apiCfg)) {
+		return
+	}
+
 	if resp.APIID, err = services.AddAPI(apiCfg); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
@@ -110,6 +117,83 @@ func AddAPI(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// cloneAPIForm fields are overrides, nil means keep the original value
+type cloneAPIForm struct {
+	Path            *string           `json:"path"`
+	Method          *string           `json:"method"`
+	TargetClusterID *string           `json:"target_cluster_id"`
+	RewritePath     *string           `json:"rewrite_path"`
+	NeedCombine     *bool             `json:"need_combine"`
+	CombineReqCfgs  []*apiCombination `json:"combinations"`
+}
+type cloneAPIResp struct {
+	code.CodeInfo
+	fieldErrors
+	APIID string `json:"api_id,omitempty"`
+}
+
+// CloneAPI copy an api config with all combinations, the path or method
+// must be overridden so the copy serves another route
+func CloneAPI(c *gin.Context) {
+	var (
+		form = new(cloneAPIForm)
+		resp = new(cloneAPIResp)
+		err  error
+	)
+
+	// an empty body means no override
+	if err = c.ShouldBindJSON(form); err != nil && err != io.EOF {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	override := func(api *models.API) error {
+		if form.Path != nil {
+			api.Path = *form.Path
+		}
+		if form.Method != nil {
+			api.Method = *form.Method
+		}
+		if form.TargetClusterID != nil {
+			api.TargetClusterID = *form.TargetClusterID
+		}
+		if form.RewritePath != nil {
+			api.RewritePath = *form.RewritePath
+		}
+		if form.NeedCombine != nil {
+			api.NeedCombine = *form.NeedCombine
+		}
+		if form.CombineReqCfgs != nil {
+			api.CombineReqCfgs = make([]*models.APICombination, len(form.CombineReqCfgs))
+			for idx, combCfg := range form.CombineReqCfgs {
+				api.CombineReqCfgs[idx] = &models.APICombination{
+					Path:            combCfg.Path,
+					Field:           combCfg.Field,
+					Method:          combCfg.Method,
+					TargetClusterID: combCfg.TargetClusterID,
+				}
+			}
+		}
+		return validate.API(api)
+	}
+
+	apiID := c.Param("apiID")
+	resp.APIID, err = services.CloneAPI(apiID, override)
+	if abortWithFieldErrors(c, resp, err) {
+		return
+	}
+	if err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
 // type delAPIForm struct{}
 type delAPIResp struct {
 	code.CodeInfo
@@ -143,6 +227,7 @@ type updateAPIForm struct {
 }
 type updateAPIResp struct {
 	code.CodeInfo
+	fieldErrors
 }
 
 // UpdateAPI update an api config
@@ -180,6 +265,10 @@ func UpdateAPI(c *gin.Context) {
 		CombineReqCfgs:  combCfgs,
 	}
 
+	if abortWithFieldErrors(c, resp, validate.API(apiCfg)) {
+		return
+	}
+
 	if err = services.UpdateAPI(apiCfg); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
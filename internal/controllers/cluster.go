@@ -1,16 +1,23 @@
 package controllers
 
 import (
-	"github.com/jademperor/common/models"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/jademperor/common/pkg/code"
 	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/healthchecking"
+	"github.com/jademperor/gateway-manager/internal/models"
 	"github.com/jademperor/gateway-manager/internal/services"
+	"github.com/jademperor/gateway-manager/internal/validate"
 )
 
+type getAllClustersForm struct {
+	Status models.ClusterStatus `form:"status"` // ok, degraded or down, empty means all
+}
+
 type getAllClustersResp struct {
 	code.CodeInfo
 	Clusters []*services.Cluster `json:"clusters"`
@@ -19,11 +26,24 @@ type getAllClustersResp struct {
 // GetAllClusters load all clusters info from
 func GetAllClusters(c *gin.Context) {
 	var (
+		form = new(getAllClustersForm)
 		resp = new(getAllClustersResp)
 		err  error
 	)
 
-	if resp.Clusters, err = services.GetAllClusters(); err != nil {
+	if err = c.ShouldBind(form); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if form.Status != "" && !form.Status.Valid() {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeParamInvalid, "invalid cluster status: "+string(form.Status)))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if resp.Clusters, err = services.GetAllClusters(form.Status); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
 		return
@@ -64,6 +84,7 @@ type addClusterJSON struct {
 }
 type addClusterResp struct {
 	code.CodeInfo
+	fieldErrors
 	ClusterID string `json:"cluster_id,omitempty"`
 }
 
@@ -82,6 +103,10 @@ func AddCluster(c *gin.Context) {
 		return
 	}
 
+	if abortWithFieldErrors(c, resp, validate.Instances(jsForm.Instances)) {
+		return
+	}
+
 	if resp.ClusterID, err = services.NewCluster(jsForm.Name, jsForm.Instances); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
@@ -93,6 +118,65 @@ func AddCluster(c *gin.Context) {
 	return
 }
 
+// cloneClusterJSON fields are overrides of the cluster option,
+// nil means keep the original value
+type cloneClusterJSON struct {
+	Name        *string                   `json:"name"`         // empty means "{name}-clone"
+	HealthCheck *models.HealthCheckPolicy `json:"health_check"` // empty secrets keep the original ones
+	MinHealthy  *int                      `json:"min_healthy"`
+}
+type cloneClusterResp struct {
+	code.CodeInfo
+	fieldErrors
+	ClusterID string `json:"cluster_id,omitempty"`
+}
+
+// CloneCluster copy a cluster with all server instances
+func CloneCluster(c *gin.Context) {
+	var (
+		jsForm = new(cloneClusterJSON)
+		resp   = new(cloneClusterResp)
+		err    error
+	)
+
+	// an empty body means no override
+	if err = c.ShouldBindJSON(jsForm); err != nil && err != io.EOF {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.ClusterOverrides(jsForm.HealthCheck, jsForm.MinHealthy)) {
+		return
+	}
+
+	override := func(clsOpt *models.ClusterOption) error {
+		if jsForm.Name != nil && *jsForm.Name != "" {
+			clsOpt.Name = *jsForm.Name
+		}
+		if jsForm.HealthCheck != nil {
+			jsForm.HealthCheck.KeepSecrets(clsOpt.HealthCheck)
+			clsOpt.HealthCheck = jsForm.HealthCheck
+		}
+		if jsForm.MinHealthy != nil {
+			clsOpt.MinHealthy = *jsForm.MinHealthy
+		}
+		return nil
+	}
+
+	clusterID := c.Param("clusterID")
+	if resp.ClusterID, err = services.CloneCluster(clusterID, override); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+	return
+}
+
 type delClusterResp struct {
 	code.CodeInfo
 }
@@ -184,8 +268,21 @@ type addClusterInsForm struct {
 	NeedCheckHealth bool   `form:"need_check_health"`
 	HealthCheckURL  string `form:"health_check_url"`
 }
+
+// instance to be validated
+func (form *addClusterInsForm) instance() *models.ServerInstance {
+	ins := new(models.ServerInstance)
+	ins.Name = form.Name
+	ins.Addr = form.Addr
+	ins.Weight = form.Weight
+	ins.NeedCheckHealth = form.NeedCheckHealth
+	ins.HealthCheckURL = form.HealthCheckURL
+	return ins
+}
+
 type addClusterInsResp struct {
 	code.CodeInfo
+	fieldErrors
 	IntanceID string `json:"instance_id"`
 }
 
@@ -204,6 +301,10 @@ func AddClusterInstance(c *gin.Context) {
 		return
 	}
 
+	if abortWithFieldErrors(c, resp, validate.Instance(form.instance())) {
+		return
+	}
+
 	clusterID := c.Param("clusterID")
 	if resp.IntanceID, err = services.AddClusterInstance(clusterID, form.Name,
 		form.Addr, form.Weight, form.NeedCheckHealth, form.HealthCheckURL); err != nil {
@@ -247,8 +348,15 @@ type updateClusterInsForm struct {
 	NeedCheckHealth bool   `form:"need_check_health"`
 	HealthCheckURL  string `form:"health_check_url"`
 }
+
+// instance to be validated
+func (form *updateClusterInsForm) instance() *models.ServerInstance {
+	return (*addClusterInsForm)(form).instance()
+}
+
 type updateClusterInsResp struct {
 	code.CodeInfo
+	fieldErrors
 }
 
 // UpdateClusterInstance update a server intance in the cluster
@@ -266,12 +374,17 @@ func UpdateClusterInstance(c *gin.Context) {
 		return
 	}
 
+	if abortWithFieldErrors(c, resp, validate.Instance(form.instance())) {
+		return
+	}
+
 	clusterID := c.Param("clusterID")
 	instanceID := c.Param("instanceID")
 	if err = services.UpdateClusterInstanceInfo(clusterID, instanceID,
 		form.Name, form.Addr, form.Weight, form.NeedCheckHealth, form.HealthCheckURL); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
+		return
 	}
 
 	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
@@ -281,7 +394,7 @@ func UpdateClusterInstance(c *gin.Context) {
 // type getClusterInsForm struct{}
 type getClusterInsResp struct {
 	code.CodeInfo
-	Instance *models.ServerInstance `json:"instance,omitempty"`
+	Instance *services.Instance `json:"instance,omitempty"`
 }
 
 // GetClusterInstance get instance detail in the cluster
@@ -296,6 +409,255 @@ func GetClusterInstance(c *gin.Context) {
 	if resp.Instance, err = services.GetClusterInstanceInfo(clusterID, instanceID); err != nil {
 		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
 		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getClusterInsHealthResp struct {
+	code.CodeInfo
+	Health        *models.HealthStatus  `json:"health"`                    // nil while the instance has not been checked
+	Probes        []*models.ProbeResult `json:"probes,omitempty"`          // recent probes, oldest first
+	CheckedBy     string                `json:"checked_by,omitempty"`      // id of the replica checking it, which holds the probes
+	CheckedByAddr string                `json:"checked_by_addr,omitempty"` // api addr of the replica, query it for the probes while they're missing
+}
+
+// GetClusterInstanceHealth get the health status and history of an instance,
+// the recent probes are served only by the replica checking the instance,
+// the other replicas name it instead
+func GetClusterInstanceHealth(c *gin.Context) {
+	var (
+		resp = new(getClusterInsHealthResp)
+		err  error
+	)
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if resp.Health, err = services.GetClusterInstanceHealth(clusterID, instanceID); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	probes, checkedBy := healthchecking.RecentProbes(clusterID, instanceID)
+	if checkedBy != nil {
+		resp.Probes = probes
+		resp.CheckedBy = checkedBy.ID
+		resp.CheckedByAddr = checkedBy.Addr
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type checkClusterInsForm struct {
+	Persist bool `form:"persist"`
+}
+
+type checkClusterInsResp struct {
+	code.CodeInfo
+	Report *healthchecking.CheckReport `json:"report,omitempty"`
+}
+
+// CheckClusterInstance probe an instance at once, the result becomes the
+// state of the instance while persist is set
+func CheckClusterInstance(c *gin.Context) {
+	var (
+		form = new(checkClusterInsForm)
+		resp = new(checkClusterInsResp)
+		err  error
+	)
+
+	if err = c.ShouldBind(form); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if resp.Report, err = healthchecking.CheckNow(clusterID, instanceID, form.Persist); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type replaceClusterInsJSON struct {
+	Instances []*models.ServerInstance `json:"instances" binding:"required"`
+}
+type replaceClusterInsResp struct {
+	code.CodeInfo
+	fieldErrors
+	Diff *services.InstancesDiff `json:"diff,omitempty"`
+}
+
+// ReplaceClusterInstances replace all instances of the cluster with the desired list
+func ReplaceClusterInstances(c *gin.Context) {
+	var (
+		jsForm = new(replaceClusterInsJSON)
+		resp   = new(replaceClusterInsResp)
+		err    error
+	)
+
+	if err = c.ShouldBindJSON(jsForm); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	if abortWithFieldErrors(c, resp, validate.Instances(jsForm.Instances)) {
+		return
+	}
+
+	if resp.Diff, err = services.ReplaceClusterInstances(clusterID, jsForm.Instances); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// type setClusterInsStateForm struct{}
+type setClusterInsStateResp struct {
+	code.CodeInfo
+}
+
+// DrainClusterInstance take a instance out of rotation
+func DrainClusterInstance(c *gin.Context) {
+	setClusterInstanceAdminState(c, models.AdminStateDraining)
+}
+
+// DisableClusterInstance mark a instance as disabled
+func DisableClusterInstance(c *gin.Context) {
+	setClusterInstanceAdminState(c, models.AdminStateDisabled)
+}
+
+// EnableClusterInstance put a instance back into rotation
+func EnableClusterInstance(c *gin.Context) {
+	setClusterInstanceAdminState(c, models.AdminStateEnabled)
+}
+
+func setClusterInstanceAdminState(c *gin.Context, state models.AdminState) {
+	var (
+		resp = new(setClusterInsStateResp)
+	)
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err := services.SetClusterInstanceAdminState(clusterID, instanceID, state); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type setHealthCheckResp struct {
+	code.CodeInfo
+	fieldErrors
+}
+
+// SetClusterHealthCheck set the default health check policy of the cluster
+func SetClusterHealthCheck(c *gin.Context) {
+	var (
+		policy = new(models.HealthCheckPolicy)
+		resp   = new(setHealthCheckResp)
+		err    error
+	)
+
+	if err = c.ShouldBindJSON(policy); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.HealthCheckPolicy(policy)) {
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	if err = services.SetClusterHealthCheck(clusterID, policy); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type setClusterMinHealthyForm struct {
+	MinHealthy int `form:"min_healthy" binding:"gte=0,lte=100"` // percent, 0 means the default
+}
+
+type setClusterMinHealthyResp struct {
+	code.CodeInfo
+}
+
+// SetClusterMinHealthy set the percent of alive instances the cluster
+// needs to be ok
+func SetClusterMinHealthy(c *gin.Context) {
+	var (
+		form = new(setClusterMinHealthyForm)
+		resp = new(setClusterMinHealthyResp)
+	)
+
+	if err := c.ShouldBind(form); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	if err := services.SetClusterMinHealthy(clusterID, form.MinHealthy); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetClusterInstanceHealthCheck set the health check policy of a instance
+func SetClusterInstanceHealthCheck(c *gin.Context) {
+	var (
+		policy = new(models.HealthCheckPolicy)
+		resp   = new(setHealthCheckResp)
+		err    error
+	)
+
+	if err = c.ShouldBindJSON(policy); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.HealthCheckPolicy(policy)) {
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err = services.SetClusterInstanceHealthCheck(clusterID, instanceID, policy); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
 	}
 
 	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
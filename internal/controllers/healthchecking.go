@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/healthchecking"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+	"github.com/jademperor/gateway-manager/internal/validate"
+)
+
+type getHealthCheckingLeaderResp struct {
+	code.CodeInfo
+	Leader   *models.Manager `json:"leader"`    // nil while there is no leader
+	IsLeader bool            `json:"is_leader"` // the replica serving the request is the leader
+}
+
+// GetHealthCheckingLeader get the leader replica
+func GetHealthCheckingLeader(c *gin.Context) {
+	var (
+		resp = new(getHealthCheckingLeaderResp)
+		err  error
+	)
+
+	if resp.Leader, err = healthchecking.Leader(); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	resp.IsLeader = healthchecking.IsLeader()
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getHealthCheckingReplicasResp struct {
+	code.CodeInfo
+	Replicas []*models.Manager `json:"replicas"` // live replicas sharing the health checks
+	Self     *models.Manager   `json:"self"`     // the replica serving the request
+	Jobs     int               `json:"jobs"`     // count of instances checked by self
+}
+
+// GetHealthCheckingReplicas get the replicas sharing health checks
+func GetHealthCheckingReplicas(c *gin.Context) {
+	resp := new(getHealthCheckingReplicasResp)
+	resp.Replicas = healthchecking.Replicas()
+	resp.Self = healthchecking.Self()
+	resp.Jobs = healthchecking.CheckingJobs()
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getHealthCheckingJobsForm struct {
+	Overdue bool `form:"overdue"` // only the overdue jobs
+}
+
+type getHealthCheckingJobsResp struct {
+	code.CodeInfo
+	*healthchecking.Introspection
+}
+
+// GetHealthCheckingJobs get the jobs and queues of the health checker
+// on the replica serving the request
+func GetHealthCheckingJobs(c *gin.Context) {
+	var (
+		form = new(getHealthCheckingJobsForm)
+		resp = new(getHealthCheckingJobsResp)
+	)
+
+	if err := c.ShouldBind(form); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Introspection = healthchecking.Introspect(form.Overdue)
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type reportPassiveHealthResp struct {
+	code.CodeInfo
+	fieldErrors
+	Saved   int      `json:"saved"`
+	Skipped []string `json:"skipped,omitempty"` // unknown instances as {cluster_id}/{instance_id}
+}
+
+// ReportPassiveHealth save the traffic of instances seen by a gateway,
+// the health checker combines it with the probes. the unknown instances
+// are skipped and listed in the response
+func ReportPassiveHealth(c *gin.Context) {
+	var (
+		batch = new(models.PassiveReportBatch)
+		resp  = new(reportPassiveHealthResp)
+		err   error
+	)
+
+	if err = c.ShouldBindJSON(batch); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.PassiveReportBatch(batch)) {
+		return
+	}
+
+	if resp.Saved, resp.Skipped, err = services.SavePassiveReports(batch); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
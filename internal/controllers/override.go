@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+	"github.com/jademperor/gateway-manager/internal/validate"
+)
+
+type setHealthOverrideJSON struct {
+	IsAlive  *bool           `json:"is_alive" binding:"required"`
+	Duration models.Duration `json:"duration" binding:"required"` // like "30m", expires after it
+	Reason   string          `json:"reason"`
+}
+
+type setHealthPauseJSON struct {
+	Duration models.Duration `json:"duration" binding:"required"` // like "30m", expires after it
+	Reason   string          `json:"reason"`
+}
+
+type setHealthHoldResp struct {
+	code.CodeInfo
+	fieldErrors
+	Until time.Time `json:"until,omitempty"`
+}
+
+// SetClusterInstanceHealthOverride force an instance alive or dead for a duration
+func SetClusterInstanceHealthOverride(c *gin.Context) {
+	var (
+		jsForm = new(setHealthOverrideJSON)
+		resp   = new(setHealthHoldResp)
+		err    error
+	)
+
+	if err = c.ShouldBindJSON(jsForm); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if abortWithFieldErrors(c, resp, validate.Hold(jsForm.Duration)) {
+		return
+	}
+
+	override := &models.HealthOverride{
+		IsAlive: *jsForm.IsAlive,
+		Until:   time.Now().Add(jsForm.Duration.Std()),
+		Reason:  jsForm.Reason,
+	}
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err = services.SetClusterInstanceHealthOverride(clusterID, instanceID, override); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Until = override.Until
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// DelClusterInstanceHealthOverride clear the health override of an instance
+func DelClusterInstanceHealthOverride(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err := services.SetClusterInstanceHealthOverride(clusterID, instanceID, nil); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindHealthPause bind and validate the pause, returns nil after responding
+func bindHealthPause(c *gin.Context, resp *setHealthHoldResp) *models.HealthPause {
+	jsForm := new(setHealthPauseJSON)
+	if err := c.ShouldBindJSON(jsForm); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return nil
+	}
+
+	if abortWithFieldErrors(c, resp, validate.Hold(jsForm.Duration)) {
+		return nil
+	}
+	return &models.HealthPause{
+		Until:  time.Now().Add(jsForm.Duration.Std()),
+		Reason: jsForm.Reason,
+	}
+}
+
+// PauseClusterInstanceHealth pause probing an instance for a duration
+func PauseClusterInstanceHealth(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+	pause := bindHealthPause(c, resp)
+	if pause == nil {
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err := services.SetClusterInstanceHealthPause(clusterID, instanceID, pause); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Until = pause.Until
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// ResumeClusterInstanceHealth resume probing an instance
+func ResumeClusterInstanceHealth(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+
+	clusterID := c.Param("clusterID")
+	instanceID := c.Param("instanceID")
+	if err := services.SetClusterInstanceHealthPause(clusterID, instanceID, nil); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// PauseClusterHealth pause probing all instances of the cluster for a duration
+func PauseClusterHealth(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+	pause := bindHealthPause(c, resp)
+	if pause == nil {
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	if err := services.SetClusterHealthPause(clusterID, pause); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Until = pause.Until
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// ResumeClusterHealth resume probing the instances of the cluster
+func ResumeClusterHealth(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+
+	clusterID := c.Param("clusterID")
+	if err := services.SetClusterHealthPause(clusterID, nil); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
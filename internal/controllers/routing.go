@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -87,6 +88,58 @@ func AddRouting(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// cloneRoutingForm fields are overrides, nil means keep the original value
+type cloneRoutingForm struct {
+	Prefix          *string `json:"prefix"`
+	ClusterID       *string `json:"target_cluster_id"`
+	NeedStripPrefix *bool   `json:"need_strip_prefix"`
+}
+type cloneRoutingResp struct {
+	code.CodeInfo
+	RoutingID string `json:"routing_id,omitempty"`
+}
+
+// CloneRouting copy an Routing config, the prefix must be overridden
+// so the copy serves another route
+func CloneRouting(c *gin.Context) {
+	var (
+		form = new(cloneRoutingForm)
+		resp = new(cloneRoutingResp)
+		err  error
+	)
+
+	// an empty body means no override
+	if err = c.ShouldBindJSON(form); err != nil && err != io.EOF {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	override := func(routing *models.Routing) error {
+		if form.Prefix != nil {
+			routing.Prefix = *form.Prefix
+		}
+		if form.ClusterID != nil {
+			routing.ClusterID = *form.ClusterID
+		}
+		if form.NeedStripPrefix != nil {
+			routing.NeedStripPrefix = *form.NeedStripPrefix
+		}
+		return nil
+	}
+
+	routingID := c.Param("routingID")
+	if resp.RoutingID, err = services.CloneRouting(routingID, override); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
 // type delRoutingForm struct{}
 type delRoutingResp struct {
 	code.CodeInfo
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+)
+
+// bindShadowMode bind and validate the shadow mode from now on,
+// returns nil after responding
+func bindShadowMode(c *gin.Context, resp *setHealthHoldResp) *models.ShadowMode {
+	pause := bindHealthPause(c, resp)
+	if pause == nil {
+		return nil
+	}
+	return &models.ShadowMode{
+		Since:  time.Now(),
+		Until:  pause.Until,
+		Reason: pause.Reason,
+	}
+}
+
+// SetClusterHealthShadow put the cluster in shadow mode for a duration,
+// the instances are probed but their health status is not written
+func SetClusterHealthShadow(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+	mode := bindShadowMode(c, resp)
+	if mode == nil {
+		return
+	}
+
+	clusterID := c.Param("clusterID")
+	if err := services.SetClusterHealthShadow(clusterID, mode); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Until = mode.Until
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// DelClusterHealthShadow leave the shadow mode of the cluster
+func DelClusterHealthShadow(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+
+	clusterID := c.Param("clusterID")
+	if err := services.SetClusterHealthShadow(clusterID, nil); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetGlobalHealthShadow put all clusters in shadow mode for a duration
+func SetGlobalHealthShadow(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+	mode := bindShadowMode(c, resp)
+	if mode == nil {
+		return
+	}
+
+	if err := services.SetGlobalShadow(mode); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.Until = mode.Until
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// DelGlobalHealthShadow leave the global shadow mode, the clusters in
+// their own shadow mode are kept in it
+func DelGlobalHealthShadow(c *gin.Context) {
+	resp := new(setHealthHoldResp)
+
+	if err := services.SetGlobalShadow(nil); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getHealthShadowReportForm struct {
+	ClusterID string `form:"cluster_id"` // only the cluster
+}
+
+type getHealthShadowReportResp struct {
+	code.CodeInfo
+	*services.ShadowReport
+}
+
+// GetHealthShadowReport compare the shadow states of the clusters in
+// shadow mode with the stored states
+func GetHealthShadowReport(c *gin.Context) {
+	var (
+		form = new(getHealthShadowReportForm)
+		resp = new(getHealthShadowReportResp)
+		err  error
+	)
+
+	if err = c.ShouldBind(form); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	if resp.ShadowReport, err = services.GetShadowReport(form.ClusterID); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/common/pkg/ginutils"
+	"github.com/jademperor/gateway-manager/internal/healthchecking"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/services"
+	"github.com/jademperor/gateway-manager/internal/validate"
+)
+
+type getAllSyntheticsResp struct {
+	code.CodeInfo
+	Synthetics []*services.Synthetic `json:"synthetics"`
+}
+
+// GetAllSynthetics get all synthetic probes with their health status
+func GetAllSynthetics(c *gin.Context) {
+	var (
+		resp = new(getAllSyntheticsResp)
+		err  error
+	)
+
+	if resp.Synthetics, err = services.GetAllSynthetics(); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// bindSyntheticProbe bind and validate a synthetic probe,
+// returns nil after responding
+func bindSyntheticProbe(c *gin.Context, resp *setSyntheticResp) *models.SyntheticProbe {
+	probe := new(models.SyntheticProbe)
+	if err := c.ShouldBindJSON(probe); err != nil {
+		err = ginutils.HdlValidationErrors(err)
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return nil
+	}
+
+	if abortWithFieldErrors(c, resp, validate.SyntheticProbe(probe)) {
+		return nil
+	}
+	return probe
+}
+
+type setSyntheticResp struct {
+	code.CodeInfo
+	fieldErrors
+	ProbeID string `json:"probe_id,omitempty"`
+}
+
+// AddSynthetic add a synthetic probe, the api or routing it targets must exist
+func AddSynthetic(c *gin.Context) {
+	var (
+		resp = new(setSyntheticResp)
+		err  error
+	)
+
+	probe := bindSyntheticProbe(c, resp)
+	if probe == nil {
+		return
+	}
+
+	if resp.ProbeID, err = services.AddSynthetic(probe); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateSynthetic replace a synthetic probe, the empty credentials of the
+// check keep the stored ones
+func UpdateSynthetic(c *gin.Context) {
+	resp := new(setSyntheticResp)
+
+	probe := bindSyntheticProbe(c, resp)
+	if probe == nil {
+		return
+	}
+
+	probe.Idx = c.Param("probeID")
+	if err := services.UpdateSynthetic(probe); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	resp.ProbeID = probe.Idx
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type getSyntheticResp struct {
+	code.CodeInfo
+	Synthetic     *services.Synthetic   `json:"synthetic,omitempty"`
+	Probes        []*models.ProbeResult `json:"probes,omitempty"`          // recent probes, newest last
+	CheckedBy     string                `json:"checked_by,omitempty"`      // id of the replica checking it, which holds the probes
+	CheckedByAddr string                `json:"checked_by_addr,omitempty"` // api addr of the replica, query it for the probes while they're missing
+}
+
+// GetSynthetic get a synthetic probe with its health status and history,
+// the recent probes are served only by the replica checking it, the other
+// replicas name it instead
+func GetSynthetic(c *gin.Context) {
+	var (
+		resp = new(getSyntheticResp)
+		err  error
+	)
+
+	probeID := c.Param("probeID")
+	if resp.Synthetic, err = services.GetSynthetic(probeID); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	probes, checkedBy := healthchecking.RecentSyntheticProbes(probeID)
+	if checkedBy != nil {
+		resp.Probes = probes
+		resp.CheckedBy = checkedBy.ID
+		resp.CheckedByAddr = checkedBy.Addr
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
+
+type delSyntheticResp struct {
+	code.CodeInfo
+}
+
+// DelSynthetic del a synthetic probe and its health status
+func DelSynthetic(c *gin.Context) {
+	resp := new(delSyntheticResp)
+
+	probeID := c.Param("probeID")
+	if err := services.DelSynthetic(probeID); err != nil {
+		code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeSystemErr, err.Error()))
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	code.FillCodeInfo(resp, code.GetCodeInfo(code.CodeOk))
+	c.JSON(http.StatusOK, resp)
+}
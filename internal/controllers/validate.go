@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jademperor/common/pkg/code"
+	"github.com/jademperor/gateway-manager/internal/validate"
+)
+
+// fieldErrors be embeded into the responses which could fail on validation
+type fieldErrors struct {
+	Errors validate.Errors `json:"errors,omitempty"`
+}
+
+func (f *fieldErrors) setErrors(errs validate.Errors) {
+	f.Errors = errs
+}
+
+type fieldErrorsSetter interface {
+	setErrors(errs validate.Errors)
+}
+
+// abortWithFieldErrors response the validation errors if there is any,
+// and returns true while the request has been responded
+func abortWithFieldErrors(c *gin.Context, resp fieldErrorsSetter, err error) bool {
+	errs, ok := err.(validate.Errors)
+	if !ok || len(errs) == 0 {
+		return false
+	}
+
+	resp.setErrors(errs)
+	code.FillCodeInfo(resp, code.NewCodeInfo(code.CodeParamInvalid, errs.Error()))
+	c.JSON(http.StatusOK, resp)
+	return true
+}
@@ -0,0 +1,141 @@
+// Package election elects a leader among manager replicas by a key with TTL
+// in the config store, the replica which holds the key is the leader.
+package election
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+)
+
+// Locker is the store to hold the leader key
+type Locker interface {
+	// Acquire set key to value with ttl while the key does not exist,
+	// returns false while it's held by others
+	Acquire(key, value string, ttl time.Duration) (bool, error)
+	// Refresh extends the ttl of key while its value is still value,
+	// returns false while the key has been lost
+	Refresh(key, value string, ttl time.Duration) (bool, error)
+	// Release delete key while its value is value
+	Release(key, value string) error
+	// Get returns value of key, "" while there is no leader
+	Get(key string) (string, error)
+}
+
+// Elector campaigns for the leader key, the leader refreshes the key
+// every TTL/3, so another replica takes over within TTL after the leader dies
+type Elector struct {
+	locker Locker
+	key    string
+	value  string
+	ttl    time.Duration
+
+	mutex     sync.Mutex
+	isLeader  bool
+	lastRenew time.Time
+	quit      chan struct{}
+}
+
+// New create an Elector campaigning for key with value which
+// identifies this replica
+func New(locker Locker, key, value string, ttl time.Duration) *Elector {
+	return &Elector{
+		locker: locker,
+		key:    key,
+		value:  value,
+		ttl:    ttl,
+		quit:   make(chan struct{}),
+	}
+}
+
+// IsLeader reports whether this replica is the leader
+func (e *Elector) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.isLeader
+}
+
+// Leader returns the value of the current leader, "" while there is no leader
+func (e *Elector) Leader() (string, error) {
+	return e.locker.Get(e.key)
+}
+
+// Run campaign until Stop is called, onChange is called
+// while this replica becomes or stops being the leader
+func (e *Elector) Run(onChange func(isLeader bool)) {
+	interval := e.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.campaign(interval, onChange)
+		select {
+		case <-ticker.C:
+		case <-e.quit:
+			if e.IsLeader() {
+				e.setLeader(false, onChange)
+				if err := e.locker.Release(e.key, e.value); err != nil {
+					logger.Logger.Errorf("Elector.Run() locker.Release(%s) got err: %v", e.key, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// Stop campaigning, the key is released if this replica is the leader
+func (e *Elector) Stop() {
+	close(e.quit)
+}
+
+func (e *Elector) campaign(interval time.Duration, onChange func(bool)) {
+	if !e.IsLeader() {
+		ok, err := e.locker.Acquire(e.key, e.value, e.ttl)
+		if err != nil {
+			logger.Logger.Errorf("Elector.campaign() locker.Acquire(%s) got err: %v", e.key, err)
+			return
+		}
+		if ok {
+			e.renewed()
+			e.setLeader(true, onChange)
+		}
+		return
+	}
+
+	ok, err := e.locker.Refresh(e.key, e.value, e.ttl)
+	switch {
+	case err == nil && ok:
+		e.renewed()
+	case err == nil && !ok:
+		logger.Logger.Infof("Elector.campaign() lost the leader key %s", e.key)
+		e.setLeader(false, onChange)
+	default:
+		logger.Logger.Errorf("Elector.campaign() locker.Refresh(%s) got err: %v", e.key, err)
+		// the key would expire before the next refresh, step down to
+		// avoid two leaders
+		e.mutex.Lock()
+		expired := time.Since(e.lastRenew)+interval >= e.ttl
+		e.mutex.Unlock()
+		if expired {
+			e.setLeader(false, onChange)
+		}
+	}
+}
+
+func (e *Elector) renewed() {
+	e.mutex.Lock()
+	e.lastRenew = time.Now()
+	e.mutex.Unlock()
+}
+
+func (e *Elector) setLeader(isLeader bool, onChange func(bool)) {
+	e.mutex.Lock()
+	changed := e.isLeader != isLeader
+	e.isLeader = isLeader
+	e.mutex.Unlock()
+
+	if changed && onChange != nil {
+		onChange(isLeader)
+	}
+}
@@ -0,0 +1,145 @@
+package election
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Init(os.TempDir()); err != nil {
+		panic(err)
+	}
+	logger.Logger.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
+
+// memStore is a local stand-in of etcd keys with ttl
+type memStore struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{values: make(map[string]string), expires: make(map[string]time.Time)}
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	if time.Now().After(s.expires[key]) {
+		delete(s.values, key)
+	}
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// memLocker is a client of memStore, which fails all calls while down
+type memLocker struct {
+	store *memStore
+	down  bool
+}
+
+var errDown = errors.New("store is unreachable")
+
+func (l *memLocker) Acquire(key, value string, ttl time.Duration) (bool, error) {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+	if l.down {
+		return false, errDown
+	}
+	if _, ok := l.store.get(key); ok {
+		return false, nil
+	}
+	l.store.values[key] = value
+	l.store.expires[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memLocker) Refresh(key, value string, ttl time.Duration) (bool, error) {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+	if l.down {
+		return false, errDown
+	}
+	if v, ok := l.store.get(key); !ok || v != value {
+		return false, nil
+	}
+	l.store.expires[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memLocker) Release(key, value string) error {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+	if l.down {
+		return errDown
+	}
+	if v, ok := l.store.get(key); ok && v == value {
+		delete(l.store.values, key)
+	}
+	return nil
+}
+
+func (l *memLocker) Get(key string) (string, error) {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+	if l.down {
+		return "", errDown
+	}
+	v, _ := l.store.get(key)
+	return v, nil
+}
+
+func (l *memLocker) setDown(down bool) {
+	l.store.mutex.Lock()
+	l.down = down
+	l.store.mutex.Unlock()
+}
+
+func Test_ElectorTakeover(t *testing.T) {
+	const ttl = 150 * time.Millisecond
+	store := newMemStore()
+	locker1, locker2 := &memLocker{store: store}, &memLocker{store: store}
+
+	e1 := New(locker1, "/managers/leader", "1", ttl)
+	go e1.Run(nil)
+	time.Sleep(20 * time.Millisecond)
+	e2 := New(locker2, "/managers/leader", "2", ttl)
+	go e2.Run(nil)
+
+	time.Sleep(ttl)
+	if !e1.IsLeader() || e2.IsLeader() {
+		t.Fatalf("want 1 to be the only leader, got: %v %v", e1.IsLeader(), e2.IsLeader())
+	}
+	if leader, _ := e2.Leader(); leader != "1" {
+		t.Errorf("want leader 1, got: %s", leader)
+	}
+
+	// 1 is partitioned from the store, it steps down and 2 takes over
+	locker1.setDown(true)
+	time.Sleep(2 * ttl)
+	if e1.IsLeader() {
+		t.Errorf("want 1 stepped down")
+	}
+	if !e2.IsLeader() {
+		t.Errorf("want 2 took over within ttl")
+	}
+	locker1.setDown(false)
+	e1.Stop()
+
+	// 2 releases the key while stopping
+	e3 := New(&memLocker{store: store}, "/managers/leader", "3", ttl)
+	e2.Stop()
+	time.Sleep(20 * time.Millisecond)
+	go e3.Run(nil)
+	defer e3.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if !e3.IsLeader() {
+		t.Errorf("want 3 to be leader after 2 released")
+	}
+}
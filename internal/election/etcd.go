@@ -0,0 +1,64 @@
+package election
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/client"
+)
+
+// etcdLocker holds the leader key in etcd v2 with compare-and-swap
+type etcdLocker struct {
+	kapi client.KeysAPI
+}
+
+// NewEtcdLocker create a Locker on etcd
+func NewEtcdLocker(kapi client.KeysAPI) Locker {
+	return &etcdLocker{kapi: kapi}
+}
+
+func (l *etcdLocker) Acquire(key, value string, ttl time.Duration) (bool, error) {
+	_, err := l.kapi.Set(context.Background(), key, value, &client.SetOptions{
+		PrevExist: client.PrevNoExist,
+		TTL:       ttl,
+	})
+	if isErrorCode(err, client.ErrorCodeNodeExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *etcdLocker) Refresh(key, value string, ttl time.Duration) (bool, error) {
+	_, err := l.kapi.Set(context.Background(), key, "", &client.SetOptions{
+		PrevValue: value,
+		TTL:       ttl,
+		Refresh:   true,
+	})
+	if isErrorCode(err, client.ErrorCodeTestFailed) || isErrorCode(err, client.ErrorCodeKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *etcdLocker) Release(key, value string) error {
+	_, err := l.kapi.Delete(context.Background(), key, &client.DeleteOptions{PrevValue: value})
+	if isErrorCode(err, client.ErrorCodeTestFailed) || isErrorCode(err, client.ErrorCodeKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (l *etcdLocker) Get(key string) (string, error) {
+	resp, err := l.kapi.Get(context.Background(), key, nil)
+	if isErrorCode(err, client.ErrorCodeKeyNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return resp.Node.Value, nil
+}
+
+func isErrorCode(err error, code int) bool {
+	cErr, ok := err.(client.Error)
+	return ok && cErr.Code == code
+}
@@ -1,16 +1,25 @@
 package healthchecking
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
 )
 
 // newHealthJob ....
-func newHealthJob(healthCheckURL, key string, dur time.Duration) *HealthJob {
+func newHealthJob(healthCheckURL, key string) *HealthJob {
 	return &HealthJob{
-		ticker:        time.NewTicker(dur),
 		lastCheckTime: time.Now(),
 		TargetURL:     healthCheckURL,
 		InstanceKey:   key,
@@ -19,11 +28,151 @@ func newHealthJob(healthCheckURL, key string, dur time.Duration) *HealthJob {
 
 // HealthJob for healthchecking ...
 type HealthJob struct {
-	ticker        *time.Ticker // time.Ticker
-	lastCheckTime time.Time    // maybe not need
-	TargetURL     string       // server instance addr
-	InstanceKey   string       // to find the instance and change it
-	// IsAlive       bool         // flag to mark the instance is available or not
+	lastCheckTime time.Time // set by the scheduler while the check starts
+	TargetURL     string    // server instance addr
+	InstanceKey   string    // to find the instance and change it
+	InstanceAddr  string    // addr of the instance, the target of tcp and grpc checks
+
+	mutex      sync.Mutex                // protect fields below
+	insPolicy  *models.HealthCheckPolicy // policy of the instance self, maybe nil
+	policy     *models.HealthCheckPolicy // policy merged with cluster defaults
+	bodyRegexp *regexp.Regexp            // compiled policy.ExpectBodyRegexp
+	regexpErr  error                     // error compiling policy.ExpectBodyRegexp, fails the http checks
+	known      bool                      // the state has been known or not
+	isAlive    bool                      // flag to mark the instance is available or not
+	successes  int                       // consecutive successes
+	failures   int                       // consecutive failures
+
+	probes      []*models.ProbeResult // ring of recent probes, probes[nextProbe] is the oldest while full
+	nextProbe   int                   // index to record the next probe
+	transitions []*models.Transition  // latest state changes, oldest first
+	flapping    bool                  // state changed too often within the flap window
+
+	override     *models.HealthOverride // forced state of the instance, maybe nil
+	insPause     *models.HealthPause    // pause of the instance, maybe nil
+	clusterPause *models.HealthPause    // pause of the cluster, maybe nil
+	pushed       effective              // effective state in the latest status queued or restored
+	shadowPushed effective              // effective state in the latest shadow status queued
+	shadowSince  time.Time              // since of the shadow mode the shadow status is queued in
+
+	weight    int             // weight of the instance
+	degraded  bool            // latency is well above the cluster median
+	pushedP50 models.Duration // p50 latency in the latest status queued
+
+	passive     *models.PassiveStats // traffic reported by gateways, maybe nil
+	passiveSeen time.Time            // time of the latest failing report counted as a failure
+}
+
+// setPolicy merge the instance policy with the cluster defaults
+func (job *HealthJob) setPolicy(insPolicy, clusterPolicy *models.HealthCheckPolicy) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	policy := mergePolicy(job.InstanceKey, insPolicy, clusterPolicy)
+	job.insPolicy = insPolicy
+	job.policy = policy
+	job.bodyRegexp, job.regexpErr = nil, nil
+	if policy.ExpectBodyRegexp != "" {
+		rgx, err := regexp.Compile(policy.ExpectBodyRegexp)
+		if err != nil {
+			logger.Logger.Errorf("job[%s] regexp.Compile(%s) got err: %v", job.InstanceKey, policy.ExpectBodyRegexp, err)
+			err = fmt.Errorf("invalid expect_body_regexp: %v", err)
+		}
+		job.bodyRegexp, job.regexpErr = rgx, err
+	}
+}
+
+// mergePolicy returns the policy of the instance merged with the cluster
+// defaults, its secrets are opened to be sent by the checks
+func mergePolicy(key string, insPolicy, clusterPolicy *models.HealthCheckPolicy) *models.HealthCheckPolicy {
+	policy := insPolicy.Merge(clusterPolicy)
+	opened, err := secrets.OpenPolicy(policy)
+	if err != nil {
+		logger.Logger.Errorf("job[%s] secrets.OpenPolicy() got err: %v", key, err)
+		return policy
+	}
+	return opened
+}
+
+// getPolicy returns the merged policy and compiled body regexp
+func (job *HealthJob) getPolicy() (*models.HealthCheckPolicy, *regexp.Regexp) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if job.policy == nil {
+		return job.insPolicy.Merge(nil), nil
+	}
+	return job.policy, job.bodyRegexp
+}
+
+// probePolicy returns the merged policy, the compiled body regexp and
+// the error compiling it
+func (job *HealthJob) probePolicy() (*models.HealthCheckPolicy, *regexp.Regexp, error) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if job.policy == nil {
+		return job.insPolicy.Merge(nil), nil, nil
+	}
+	return job.policy, job.bodyRegexp, job.regexpErr
+}
+
+// setState set the known state of the instance, counters are reset.
+// returns true while the state has changed
+func (job *HealthJob) setState(isAlive bool) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	changed := !job.known || job.isAlive != isAlive
+	job.known = true
+	job.isAlive = isAlive
+	job.successes = 0
+	job.failures = 0
+	return changed
+}
+
+// state returns the current state and whether it's known
+func (job *HealthJob) state() (isAlive, known bool) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	return job.isAlive, job.known
+}
+
+// observe feed a check result into the job, the state changes only after
+// policy.Rise consecutive successes or policy.Fall consecutive failures.
+// the first result decides the state while it's unknown.
+// returns true while the state has changed.
+func (job *HealthJob) observe(isAlive bool) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if isAlive {
+		job.successes++
+		job.failures = 0
+	} else {
+		job.failures++
+		job.successes = 0
+	}
+
+	policy := job.policy
+	if policy == nil {
+		policy = job.insPolicy.Merge(nil)
+	}
+
+	switch {
+	case !job.known:
+		job.known = true
+		job.isAlive = isAlive
+		return true
+	case !job.isAlive && job.successes >= policy.Rise:
+		job.isAlive = true
+		return true
+	case job.isAlive && job.failures >= policy.Fall:
+		job.isAlive = false
+		return true
+	}
+	return false
 }
 
 // HealthChecker ...
@@ -32,34 +181,141 @@ type HealthChecker struct {
 }
 
 type checkResult struct {
-	IsAlive bool
-	Key     string
+	IsAlive    bool
+	Key        string
+	CheckTime  time.Time
+	Err        string
+	Latency    time.Duration
+	StatusCode int    // http checks only
+	Signal     string // models.SignalPassive for results of gateway reports, empty means active
+
+	job *HealthJob // the job checked, to drop results of replaced jobs
 }
 
-// Check server instance is alive or not.
-// HTTP response StatusOK(200) marked as success
-func (checker *HealthChecker) Check(job *HealthJob, result chan<- checkResult) {
-	// if checker.client == nil {
-	// 	checker.client = &http.Client{Timeout: 5 * time.Second}
-	// }
-	select {
-	case <-job.ticker.C:
-		resp, err := checker.client.Get(job.TargetURL)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			logger.Logger.Infof("instance[URL: %s, key: %s] is healthy:", job.TargetURL, job.InstanceKey)
-			result <- checkResult{IsAlive: true, Key: job.InstanceKey}
-			return
-		}
-		if err != nil {
-			logger.Logger.Errorf("(checker *HealthChecker) Check() got err: %v with TargetURL: [%s]", err, job.TargetURL)
+// probe check the instance with the protocol of the job policy.
+// for http, the instance is healthy while the status code is expected and
+// the body contains ExpectBody and matches ExpectBodyRegexp if they are set,
+// it fails while ExpectBodyRegexp is invalid
+func (checker *HealthChecker) probe(job *HealthJob) checkResult {
+	policy, bodyRegexp, regexpErr := job.probePolicy()
+	cr := checkResult{Key: job.InstanceKey, CheckTime: time.Now()}
+
+	target := job.TargetURL
+	var err error
+	switch policy.Type {
+	case models.CheckTCP, models.CheckGRPC:
+		if target, err = targetAddr(policy, job.InstanceAddr); err != nil {
+			break
 		}
-		if resp != nil {
-			logger.Logger.Errorf("(checker *HealthChecker) Check() got StatusCode: %d", resp.StatusCode)
+		if policy.Type == models.CheckTCP {
+			err = probeTCP(target, policy.Timeout.Std())
+		} else {
+			err = probeGRPC(target, policy.GRPCService, policy.Timeout.Std())
 		}
-		result <- checkResult{IsAlive: false, Key: job.InstanceKey}
 	default:
+		cr.StatusCode, err = checker.probeHTTP(target, policy, bodyRegexp, regexpErr)
+	}
+	cr.Latency = time.Since(cr.CheckTime)
+	if err != nil {
+		logger.Logger.Errorf("(checker *HealthChecker) probe() got err: %v with %s target: [%s]", err, policy.Type, target)
+		cr.Err = err.Error()
+		return cr
+	}
+
+	logger.Logger.Infof("instance[%s target: %s, key: %s] is healthy:", policy.Type, target, job.InstanceKey)
+	cr.IsAlive = true
+	return cr
+}
+
+// maxBodySize is the max size of the body to be matched
+const maxBodySize = 64 << 10
+
+// probeHTTP returns the status code of the response, and an error while
+// the response is not expected. regexpErr is returned without requesting,
+// the body can't be checked while the regexp is invalid
+func (checker *HealthChecker) probeHTTP(targetURL string,
+	policy *models.HealthCheckPolicy, bodyRegexp *regexp.Regexp, regexpErr error) (int, error) {
+	if regexpErr != nil {
+		return 0, regexpErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout.Std())
+	defer cancel()
+
+	var reqBody io.Reader
+	if policy.Body != "" {
+		reqBody = strings.NewReader(policy.Body)
+	}
+	req, err := http.NewRequest(policy.Method, targetURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	for name, value := range policy.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if auth := policy.Auth; auth != nil {
+		switch auth.Type {
+		case models.AuthBasic:
+			req.SetBasicAuth(auth.Username, auth.Password)
+		case models.AuthBearer:
+			req.Header.Set("Authorization", "Bearer "+auth.Token)
+		}
 	}
 
+	client := checker.client
+	if policy.TLS != nil {
+		transport, err := transportOf(policy.TLS)
+		if err != nil {
+			return 0, err
+		}
+		client = &http.Client{Transport: transport}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// drain the body unread, so the keep-alive connection is reused
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+		resp.Body.Close()
+	}()
+
+	if !matchStatus(policy.ExpectStatus, resp.StatusCode) {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if policy.ExpectBody == "" && bodyRegexp == nil {
+		return resp.StatusCode, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if policy.ExpectBody != "" && !bytes.Contains(body, []byte(policy.ExpectBody)) {
+		return resp.StatusCode, fmt.Errorf("body does not contain %q", policy.ExpectBody)
+	}
+	if bodyRegexp != nil && !bodyRegexp.Match(body) {
+		return resp.StatusCode, fmt.Errorf("body does not match %q", bodyRegexp.String())
+	}
+	return resp.StatusCode, nil
+}
+
+// matchStatus reports whether code is one of the expected codes or ranges
+func matchStatus(expect []string, code int) bool {
+	for _, s := range expect {
+		lo, hi, err := models.ParseStatusRange(s)
+		if err != nil {
+			continue
+		}
+		if code >= lo && code <= hi {
+			return true
+		}
+	}
+	return false
 }
 
 // Close ...
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
-	"time"
 )
 
 var (
@@ -16,8 +15,9 @@ var (
 type Factory func() (*HealthChecker, error)
 
 func defaultChekerFactory() (*HealthChecker, error) {
+	// the timeout of each check is set by its policy
 	return &HealthChecker{
-		client: &http.Client{Timeout: 5 * time.Second},
+		client: &http.Client{},
 	}, nil
 }
 
@@ -131,3 +131,9 @@ func (p *chanCheckerPool) Len() int {
 	checkers, _ := p.getConnsAndFactory()
 	return len(checkers)
 }
+
+// Cap returns the max count of idle checkers in the pool
+func (p *chanCheckerPool) Cap() int {
+	checkers, _ := p.getConnsAndFactory()
+	return cap(checkers)
+}
@@ -1,19 +1,173 @@
 package healthchecking
 
 import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
 )
 
-func Test_Checker(t *testing.T) {
-	job := newHealthJob("http://127.0.0.1:9091/health", "/cluster/1/1", 5*time.Second)
-	checker := &HealthChecker{}
+func TestMain(m *testing.M) {
+	if err := logger.Init(os.TempDir()); err != nil {
+		panic(err)
+	}
+	logger.Logger.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
 
-	cr := make(chan checkResult)
-	checker.Check(job, cr)
-	close(cr)
+func Test_Checker(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/cluster/1/1")
+	checker, _ := defaultChekerFactory()
 
-	if r := <-cr; r.IsAlive {
+	if r := checker.probe(job); r.IsAlive {
 		t.Errorf("want false, got: %v", r.IsAlive)
 	}
 }
+
+func Test_HealthJobObserve(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	job.setPolicy(&models.HealthCheckPolicy{Rise: 2, Fall: 3}, nil)
+
+	steps := []struct {
+		isAlive bool
+		changed bool
+		want    bool
+	}{
+		{isAlive: true, changed: true, want: true}, // unknown state, the first result decides
+		{isAlive: false, changed: false, want: true},
+		{isAlive: false, changed: false, want: true},
+		{isAlive: true, changed: false, want: true}, // failures reset
+		{isAlive: false, changed: false, want: true},
+		{isAlive: false, changed: false, want: true},
+		{isAlive: false, changed: true, want: false}, // fall = 3
+		{isAlive: true, changed: false, want: false},
+		{isAlive: true, changed: true, want: true}, // rise = 2
+	}
+
+	for idx, step := range steps {
+		if changed := job.observe(step.isAlive); changed != step.changed {
+			t.Errorf("step %d: want changed %v, got: %v", idx, step.changed, changed)
+		}
+		if isAlive, _ := job.state(); isAlive != step.want {
+			t.Errorf("step %d: want isAlive %v, got: %v", idx, step.want, isAlive)
+		}
+	}
+}
+
+func Test_HealthJobPolicyDefaults(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	job.setPolicy(nil, &models.HealthCheckPolicy{Fall: 2})
+
+	if job.policy.Rise != models.DefaultRise || job.policy.Fall != 2 {
+		t.Errorf("want rise=%d fall=2, got: rise=%d fall=%d",
+			models.DefaultRise, job.policy.Rise, job.policy.Fall)
+	}
+}
+
+func Test_HealthJobPolicyDisabled(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	clusterPolicy := &models.HealthCheckPolicy{Jitter: 0.5, DegradedWeight: 50, PassiveErrorRate: 0.3}
+
+	job.setPolicy(&models.HealthCheckPolicy{Jitter: models.Disabled}, clusterPolicy)
+	if job.policy.Jitter != 0 || job.policy.DegradedWeight != 50 || job.policy.PassiveErrorRate != 0.3 {
+		t.Errorf("want jitter turned off and the others inherited, got: %+v", job.policy)
+	}
+
+	job.setPolicy(&models.HealthCheckPolicy{DegradedWeight: models.Disabled, PassiveErrorRate: models.Disabled}, clusterPolicy)
+	if job.policy.Jitter != 0.5 || job.policy.DegradedWeight != 0 || job.policy.PassiveErrorRate != 0 {
+		t.Errorf("want degraded weight and passive checks turned off, got: %+v", job.policy)
+	}
+
+	// turned off by the cluster
+	job.setPolicy(nil, &models.HealthCheckPolicy{Jitter: models.Disabled})
+	if job.policy.Jitter != 0 {
+		t.Errorf("want jitter turned off by the cluster, got: %v", job.policy.Jitter)
+	}
+}
+
+func Test_CheckerProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		case "/host":
+			w.Write([]byte("host=" + req.Host + " token=" + req.Header.Get("X-Token")))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.Write([]byte(`{"status": "UP"}`))
+		}
+	}))
+	defer srv.Close()
+
+	checker, _ := defaultChekerFactory()
+	cases := []struct {
+		name   string
+		path   string
+		policy *models.HealthCheckPolicy
+		want   bool
+	}{
+		{name: "default", path: "/", want: true},
+		{name: "status not expected", path: "/created", want: false},
+		{name: "status in range", path: "/created",
+			policy: &models.HealthCheckPolicy{ExpectStatus: []string{"200-204"}}, want: true},
+		{name: "body contains", path: "/",
+			policy: &models.HealthCheckPolicy{ExpectBody: `"UP"`}, want: true},
+		{name: "body not contains", path: "/",
+			policy: &models.HealthCheckPolicy{ExpectBody: "DOWN"}, want: false},
+		{name: "body regexp", path: "/",
+			policy: &models.HealthCheckPolicy{ExpectBodyRegexp: `"status":\s*"UP"`}, want: true},
+		{name: "invalid body regexp", path: "/",
+			policy: &models.HealthCheckPolicy{ExpectBodyRegexp: `"status":\s*(`}, want: false},
+		{name: "headers and host", path: "/host",
+			policy: &models.HealthCheckPolicy{
+				Headers:    map[string]string{"host": "svc.internal", "X-Token": "t"},
+				ExpectBody: "host=svc.internal token=t",
+			}, want: true},
+		{name: "timeout", path: "/slow",
+			policy: &models.HealthCheckPolicy{Timeout: models.Duration(50 * time.Millisecond)}, want: false},
+	}
+
+	for _, c := range cases {
+		job := newHealthJob(srv.URL+c.path, "/clusters/1/1")
+		job.setPolicy(c.policy, nil)
+		if cr := checker.probe(job); cr.IsAlive != c.want {
+			t.Errorf("%s: want %v, got: %v (err: %s)", c.name, c.want, cr.IsAlive, cr.Err)
+		}
+	}
+}
+
+func Test_CheckerProbeHTTPReuse(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 32<<10))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// the body is not checked, it's drained so the connection is reused
+	checker, _ := defaultChekerFactory()
+	job := newHealthJob(srv.URL, "/clusters/1/1")
+	for i := 0; i < 3; i++ {
+		if cr := checker.probe(job); !cr.IsAlive {
+			t.Fatalf("want alive, got err: %s", cr.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("want the connection reused, got %d connections", n)
+	}
+}
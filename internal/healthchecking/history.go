@@ -0,0 +1,243 @@
+package healthchecking
+
+import (
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// maxProbes is the size of the probe ring of each job
+const maxProbes = 50
+
+// record put the probe result into the ring, the oldest one is overwritten
+func (job *HealthJob) record(cr checkResult) {
+	probe := &models.ProbeResult{
+		Time:       cr.CheckTime,
+		Latency:    models.Duration(cr.Latency),
+		IsAlive:    cr.IsAlive,
+		StatusCode: cr.StatusCode,
+		Error:      cr.Err,
+	}
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if len(job.probes) < maxProbes {
+		job.probes = append(job.probes, probe)
+	} else {
+		job.probes[job.nextProbe] = probe
+	}
+	job.nextProbe = (job.nextProbe + 1) % maxProbes
+}
+
+// recentProbes returns the probes in the ring, oldest first
+func (job *HealthJob) recentProbes() []*models.ProbeResult {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.recentProbesLocked()
+}
+
+// latestResult returns the result of the latest probe, for the status
+// written without a new probe
+func (job *HealthJob) latestResult(now time.Time) checkResult {
+	cr := checkResult{Key: job.InstanceKey, CheckTime: now}
+	if probe := job.lastProbe(); probe != nil {
+		cr.CheckTime, cr.Err = probe.Time, probe.Error
+	}
+	return cr
+}
+
+// lastProbe returns the latest probe, nil while never probed
+func (job *HealthJob) lastProbe() *models.ProbeResult {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if len(job.probes) == 0 {
+		return nil
+	}
+	return job.probes[(job.nextProbe+maxProbes-1)%maxProbes]
+}
+
+func (job *HealthJob) recentProbesLocked() []*models.ProbeResult {
+	probes := make([]*models.ProbeResult, 0, len(job.probes))
+	if len(job.probes) == maxProbes {
+		probes = append(probes, job.probes[job.nextProbe:]...)
+		return append(probes, job.probes[:job.nextProbe]...)
+	}
+	return append(probes, job.probes...)
+}
+
+// transit log the current state as a transition made by the check
+func (job *HealthJob) transit(cr checkResult) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	signal := cr.Signal
+	if signal == "" {
+		signal = models.SignalActive
+	}
+	job.transitions = append(job.transitions, &models.Transition{
+		IsAlive: job.isAlive,
+		Time:    cr.CheckTime,
+		Error:   cr.Err,
+		Signal:  signal,
+	})
+	if n := len(job.transitions); n > models.MaxTransitions {
+		job.transitions = job.transitions[n-models.MaxTransitions:]
+	}
+}
+
+// updateFlapping mark the job flapping while the transitions within the
+// flap window reach the threshold, returns true while the flag changed
+func (job *HealthJob) updateFlapping(now time.Time) bool {
+	policy, _ := job.getPolicy()
+	since := now.Add(-policy.FlapWindow.Std())
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	count := 0
+	for _, t := range job.transitions {
+		if t.Time.After(since) {
+			count++
+		}
+	}
+	flapping := count >= policy.FlapThreshold
+	changed := flapping != job.flapping
+	job.flapping = flapping
+	return changed
+}
+
+// status returns the health status to be written after the check,
+// IsAlive is forced while the health override is active
+func (job *HealthJob) status(cr checkResult) *models.HealthStatus {
+	return job.newStatus(cr, nil)
+}
+
+// shadowStatus returns the health status reached in the shadow mode,
+// the status queued is kept as it is
+func (job *HealthJob) shadowStatus(cr checkResult, mode *models.ShadowMode) *models.HealthStatus {
+	return job.newStatus(cr, mode)
+}
+
+// newStatus returns the health status after the check, and marks it
+// queued as the status or the shadow status while mode is set
+func (job *HealthJob) newStatus(cr checkResult, mode *models.ShadowMode) *models.HealthStatus {
+	state := job.effectiveState(cr.CheckTime)
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	status := &models.HealthStatus{
+		IsAlive:       state.isAlive,
+		Overridden:    state.overridden,
+		LastCheckTime: cr.CheckTime,
+		LastError:     cr.Err,
+		Flapping:      job.flapping,
+		Transitions:   append([]*models.Transition(nil), job.transitions...),
+		Latency:       job.latencyStatsLocked(),
+		Degraded:      job.degraded,
+		Weight:        job.effectiveWeightLocked(),
+		Passive:       job.passive,
+	}
+	if n := len(job.transitions); n > 0 && job.transitions[n-1].IsAlive == job.isAlive {
+		status.Since = job.transitions[n-1].Time
+	}
+
+	if mode != nil {
+		job.shadowPushed, job.shadowSince = state, mode.Since
+		return status
+	}
+	job.pushed = state
+	if status.Latency != nil {
+		job.pushedP50 = status.Latency.P50
+	}
+	return status
+}
+
+// restore the state and history from the written status
+func (job *HealthJob) restore(status *models.HealthStatus) {
+	job.setState(status.IsAlive)
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.flapping = status.Flapping
+	job.degraded = status.Degraded
+	job.transitions = append([]*models.Transition(nil), status.Transitions...)
+	job.pushed = effective{isAlive: status.IsAlive, known: true, overridden: status.Overridden}
+}
+
+// inherit the state and history of the replaced job
+func (job *HealthJob) inherit(old *HealthJob) {
+	isAlive, known := old.state()
+	if known {
+		job.setState(isAlive)
+	}
+
+	old.mutex.Lock()
+	probes := old.recentProbesLocked()
+	transitions := append([]*models.Transition(nil), old.transitions...)
+	flapping := old.flapping
+	pushed := old.pushed
+	degraded, pushedP50 := old.degraded, old.pushedP50
+	old.mutex.Unlock()
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.probes = probes
+	job.nextProbe = len(probes) % maxProbes
+	job.transitions = transitions
+	job.flapping = flapping
+	job.pushed = pushed
+	job.degraded = degraded
+	job.pushedP50 = pushedP50
+}
+
+// RecentProbes returns the recent probes of the instance and the replica
+// checking it. the probes are kept in memory of that replica, so they're
+// nil while it's another one, query checkedBy for them then. checkedBy is
+// nil while no replica checks the instance
+func RecentProbes(clusterID, instanceID string) (probes []*models.ProbeResult, checkedBy *models.Manager) {
+	return recentProbesOf(utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID))
+}
+
+// RecentSyntheticProbes returns the recent probes of the synthetic probe
+// and the replica checking it, like RecentProbes
+func RecentSyntheticProbes(probeID string) (probes []*models.ProbeResult, checkedBy *models.Manager) {
+	return recentProbesOf(models.SyntheticKey(probeID))
+}
+
+// recentProbesOf returns the recent probes of the job checked by this
+// replica, or the replica checking it
+func recentProbesOf(key string) ([]*models.ProbeResult, *models.Manager) {
+	taskQMutex.RLock()
+	job, ok := taskQ[key]
+	checking := ok && sched != nil && sched.has(key)
+	owner := ""
+	if ok && ring != nil {
+		owner = ring.Owner(key)
+	}
+	taskQMutex.RUnlock()
+
+	if checking {
+		return job.recentProbes(), selfReplica
+	}
+	if owner == "" || (selfReplica != nil && owner == selfReplica.ID) {
+		return nil, nil
+	}
+	return nil, replicaOf(owner)
+}
+
+// replicaOf returns the live replica of id, only the id is known while
+// it's not found in the live replicas
+func replicaOf(id string) *models.Manager {
+	if replicas != nil {
+		for _, replica := range replicas.Members() {
+			if replica.ID == id {
+				return replica
+			}
+		}
+	}
+	return &models.Manager{ID: id}
+}
@@ -0,0 +1,97 @@
+package healthchecking
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/membership"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_HealthJobProbeRing(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	start := time.Now()
+	for i := 0; i < maxProbes+5; i++ {
+		job.record(checkResult{CheckTime: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	probes := job.recentProbes()
+	if len(probes) != maxProbes {
+		t.Fatalf("want %d probes, got: %d", maxProbes, len(probes))
+	}
+	if !probes[0].Time.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("want the oldest kept probe first, got: %s", probes[0].Time)
+	}
+	for i := 1; i < len(probes); i++ {
+		if !probes[i].Time.After(probes[i-1].Time) {
+			t.Fatalf("want probes oldest first, got %s after %s", probes[i].Time, probes[i-1].Time)
+		}
+	}
+}
+
+func Test_HandleResultFlapping(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	job.setPolicy(&models.HealthCheckPolicy{FlapThreshold: 3, FlapWindow: models.Duration(time.Minute)}, nil)
+	taskQ[job.InstanceKey] = job
+
+	store := new(countingStore)
+	writer := newStatusWriter(store, time.Hour, 100)
+	start := time.Now()
+	result := func(sec int, isAlive bool) checkResult {
+		return checkResult{Key: job.InstanceKey, IsAlive: isAlive, CheckTime: start.Add(time.Duration(sec) * time.Second)}
+	}
+
+	// up, down, up within a minute, the third transition marks flapping
+	for sec, isAlive := range []bool{true, false, true, true} {
+		handleResult(result(sec, isAlive), writer)
+	}
+	status := job.status(result(4, true))
+	if !status.Flapping || len(status.Transitions) != 3 {
+		t.Errorf("want flapping with 3 transitions, got: %v %d", status.Flapping, len(status.Transitions))
+	}
+	if n := writer.flush(); n != 1 {
+		t.Errorf("want the status written, got: %d", n)
+	}
+
+	// no transition within the window, the flag is cleared and written
+	handleResult(result(120, true), writer)
+	if status := job.status(result(120, true)); status.Flapping {
+		t.Errorf("want flapping cleared")
+	}
+	if n := writer.flush(); n != 1 {
+		t.Errorf("want the cleared flag written, got: %d", n)
+	}
+	handleResult(result(121, true), writer)
+	if n := writer.flush(); n != 0 {
+		t.Errorf("want nothing written without change, got: %d", n)
+	}
+}
+
+func Test_RecentProbesCheckedBy(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	sched = newScheduler(1, nil, nil)
+	selfReplica = &models.Manager{ID: "a"}
+	ring = membership.NewRing([]string{"a", "b"})
+	defer func() { sched, ring = nil, nil }()
+
+	keys := make(map[string]string) // owner to key
+	for i := 0; len(keys) < 2; i++ {
+		key := fmt.Sprintf("/clusters/1/%d", i)
+		keys[ring.Owner(key)] = key
+		job := newHealthJob("http://127.0.0.1/health", key)
+		job.record(checkResult{CheckTime: time.Now()})
+		addJob(key, job)
+	}
+
+	if probes, checkedBy := recentProbesOf(keys["a"]); len(probes) != 1 || checkedBy != selfReplica {
+		t.Errorf("want the probes served by this replica, got: %v %+v", probes, checkedBy)
+	}
+	if probes, checkedBy := recentProbesOf(keys["b"]); probes != nil || checkedBy == nil || checkedBy.ID != "b" {
+		t.Errorf("want the replica holding the probes named, got: %v %+v", probes, checkedBy)
+	}
+	if probes, checkedBy := recentProbesOf("/clusters/1/missing"); probes != nil || checkedBy != nil {
+		t.Errorf("want nothing for the instance not checked, got: %v %+v", probes, checkedBy)
+	}
+}
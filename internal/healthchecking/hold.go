@@ -0,0 +1,103 @@
+package healthchecking
+
+import (
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// setHolds set the health override and the pauses of the job
+func (job *HealthJob) setHolds(override *models.HealthOverride, insPause, clusterPause *models.HealthPause) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	job.override = override
+	job.insPause = insPause
+	job.clusterPause = clusterPause
+}
+
+// setClusterPause set the pause of the cluster
+func (job *HealthJob) setClusterPause(pause *models.HealthPause) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	job.clusterPause = pause
+}
+
+// pausedUntil returns the time the job is paused until, zero while not paused
+func (job *HealthJob) pausedUntil(now time.Time) time.Time {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	var until time.Time
+	for _, pause := range []*models.HealthPause{job.insPause, job.clusterPause} {
+		if pause.Active(now) && pause.Until.After(until) {
+			until = pause.Until
+		}
+	}
+	return until
+}
+
+// effective is the state of an instance seen by gateways
+type effective struct {
+	isAlive    bool
+	known      bool
+	overridden bool // isAlive is forced by the health override
+}
+
+// effectiveState returns the state forced by the active health override,
+// or the checked state
+func (job *HealthJob) effectiveState(now time.Time) effective {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if job.override.Active(now) {
+		return effective{isAlive: job.override.IsAlive, known: true, overridden: true}
+	}
+	return effective{isAlive: job.isAlive, known: job.known}
+}
+
+// clusterPause returns the pause of the cluster, maybe nil
+func clusterPause(clusterID string) *models.HealthPause {
+	clusterOptsMutex.RLock()
+	defer clusterOptsMutex.RUnlock()
+
+	if clsOpt, ok := clusterOpts[clusterID]; ok {
+		return clsOpt.HealthPause
+	}
+	return nil
+}
+
+// effectiveChanged reports whether the effective state differs from
+// the one in the latest status queued, or the latest shadow status
+// queued in the same shadow mode while the job is in shadow mode
+func (job *HealthJob) effectiveChanged(now time.Time) bool {
+	state := job.effectiveState(now)
+	mode := shadowOf(job.InstanceKey, now)
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if mode != nil {
+		return state.known && (state != job.shadowPushed || !job.shadowSince.Equal(mode.Since))
+	}
+	return state.known && state != job.pushed
+}
+
+// pushIfEffectiveChanged queue the status of the job at once while its
+// effective state changed and this replica checks the job, so setting or
+// clearing a health override takes effect without waiting for a probe
+func pushIfEffectiveChanged(key string, job *HealthJob) {
+	now := time.Now()
+	if !job.effectiveChanged(now) {
+		return
+	}
+
+	taskQMutex.RLock()
+	checking := sched != nil && sched.has(key)
+	taskQMutex.RUnlock()
+	if checking {
+		logger.Logger.Infof("instance[%s] effective state changed by the health override or shadow mode", key)
+		pushStatus(writer, job, checkResult{Key: key, CheckTime: now})
+	}
+}
@@ -0,0 +1,74 @@
+package healthchecking
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_SchedulerPause(t *testing.T) {
+	var probes int32
+	probe := func(job *HealthJob) checkResult {
+		atomic.AddInt32(&probes, 1)
+		return checkResult{Key: job.InstanceKey, IsAlive: true}
+	}
+	results := make(chan checkResult, 100)
+	s := newScheduler(1, probe, results)
+	s.rand = func() float64 { return 0 }
+	go s.run()
+	defer s.stop()
+
+	job := newIntervalJob("/clusters/1/1", 10*time.Millisecond)
+	job.setHolds(nil, nil, &models.HealthPause{Until: time.Now().Add(time.Hour)})
+	s.add(job)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&probes); n != 0 {
+		t.Fatalf("want no probes while paused, got: %d", n)
+	}
+
+	job.setClusterPause(nil)
+	s.touch(job.InstanceKey)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&probes); n == 0 {
+		t.Errorf("want probes after resumed")
+	}
+}
+
+func Test_HandleResultOverride(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	job.setHolds(&models.HealthOverride{IsAlive: false, Until: time.Now().Add(time.Hour)}, nil, nil)
+	taskQ[job.InstanceKey] = job
+
+	store := new(countingStore)
+	writer := newStatusWriter(store, time.Hour, 100)
+	written := func() *models.HealthStatus {
+		writer.flush()
+		status := new(models.HealthStatus)
+		etcdutils.Decode(store.data[models.HealthStatusKeyOf(job.InstanceKey)], status)
+		return status
+	}
+
+	handleResult(checkResult{Key: job.InstanceKey, IsAlive: true, CheckTime: time.Now()}, writer)
+	if status := written(); status.IsAlive || !status.Overridden {
+		t.Errorf("want forced dead, got: %+v", status)
+	}
+	if isAlive, _ := job.state(); !isAlive {
+		t.Errorf("want the checked state kept alive")
+	}
+
+	// the override expires, the checked state is written without a transition
+	job.setHolds(&models.HealthOverride{IsAlive: false, Until: time.Now().Add(-time.Second)}, nil, nil)
+	handleResult(checkResult{Key: job.InstanceKey, IsAlive: true, CheckTime: time.Now()}, writer)
+	if status := written(); !status.IsAlive || status.Overridden {
+		t.Errorf("want alive after the override expired, got: %+v", status)
+	}
+	sets := store.sets
+	handleResult(checkResult{Key: job.InstanceKey, IsAlive: true, CheckTime: time.Now()}, writer)
+	if writer.flush(); store.sets != sets {
+		t.Errorf("want nothing written without change")
+	}
+}
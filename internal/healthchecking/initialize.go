@@ -1,52 +1,153 @@
 package healthchecking
 
 import (
-	"context"
 	// "encoding/json"
-	"errors"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jademperor/common/configs"
 	"github.com/jademperor/common/etcdutils"
-	"github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/election"
 	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/membership"
+	"github.com/jademperor/gateway-manager/internal/models"
 	"go.etcd.io/etcd/client"
 )
 
 var (
-	clusterWatcher *etcdutils.Watcher    // clusterWatcher for update taskQ
-	taskQ          map[string]*HealthJob // taskQ is map of server instance health cheker
-	taskQMutex     sync.RWMutex          // read write locker for taskQ
-	// HealthCheckTTL (second)
-	HealthCheckTTL = 10 * time.Second // default health job ticker duration
+	store            *etcdutils.EtcdStore             // store to load instances and save health status
+	clusterWatcher   *clusterWatch                    // clusterWatcher for update taskQ
+	taskQ            map[string]*HealthJob            // taskQ is map of server instance health cheker
+	taskQMutex       sync.RWMutex                     // read write locker for taskQ
+	clusterOpts      map[string]*models.ClusterOption // clusterOpts is map of cluster options which has health check defaults
+	clusterOptsMutex sync.RWMutex                     // read write locker for clusterOpts
+	sched            *scheduler                       // sched runs jobs in taskQ owned by this replica
+	checkerPool      *chanCheckerPool                 // checkers of sched and on-demand checks
+	writer           *statusWriter                    // writer of health status
+	elector          *election.Elector                // elector of the leader replica
 )
 
-// Init ...
-func Init(etcdAddrs []string, watchDuration time.Duration) {
-	store, err := etcdutils.NewEtcdStore(etcdAddrs)
+// defaultWorkers is the max count of checks running at the same time
+const defaultWorkers = 100
+
+// Init load jobs and watch the clusters, taskQ is reconciled with the stored
+// instances every reconcileInterval. the jobs are sharded across the live
+// replicas, this replica self checks the jobs it owns. a replica which
+// dies is taken over within ttl, and so is the leader.
+func Init(etcdAddrs []string, reconcileInterval time.Duration, self *models.Manager, ttl time.Duration) {
+	var err error
+	store, err = etcdutils.NewEtcdStore(etcdAddrs)
 	if err != nil {
 		panic(err)
 	}
 
 	taskQ = make(map[string]*HealthJob)
 	taskQMutex = sync.RWMutex{}
+	clusterOpts = make(map[string]*models.ClusterOption)
+	clusterOptsMutex = sync.RWMutex{}
 
-	if err := initTaskQ(store.Kapi); err != nil {
+	// the pool holds a checker for each worker, so checkers are reused
+	checkerPool, err = newCheckerPool(10, defaultWorkers, defaultChekerFactory)
+	if err != nil {
 		panic(err)
 	}
+	chanCheckResult := make(chan checkResult, 100)
+	writer = newStatusWriter(store, defaultFlushInterval, defaultFlushSize)
+	writer.mirror = func(instanceKey string, status *models.HealthStatus) error {
+		return mirrorInstance(store.Kapi, instanceKey, status)
+	}
+	shadowWriter = newStatusWriter(store, defaultFlushInterval, defaultFlushSize)
+	shadowWriter.keyOf = models.ShadowStatusKeyOf
+	sched = newScheduler(defaultWorkers, poolProbe(checkerPool), chanCheckResult)
+	selfReplica = self
+	handoverGrace = ttl
 
-	// while clusters instance changed
-	clusterWatcher = etcdutils.NewWatcher(store.Kapi, watchDuration, configs.ClustersKey)
-	go clusterWatcher.Watch(clusterWatchCallback)
+	mode, err := loadGlobalShadow()
+	if err != nil {
+		panic(err)
+	}
+	globalShadow.Store(mode)
 
-	checkerPool, err := newCheckerPool(10, 100, defaultChekerFactory)
+	index, err := initTaskQ(store.Kapi)
 	if err != nil {
 		panic(err)
 	}
 
-	go healthChecking(store, checkerPool)
+	if err := syncSynthetics(store.Kapi); err != nil {
+		logger.Logger.Errorf("healthchecking load synthetic probes got err: %v", err)
+	}
+
+	// while clusters instance changed
+	clusterWatcher = newClusterWatch(store.Kapi, index, reconcileInterval)
+	go clusterWatcher.run(clusterWatchCallback)
+
+	go healthChecking(chanCheckResult)
+	go sched.run()
+	go detectOutliers(defaultOutlierInterval)
+	go detectPassive(defaultPassiveInterval)
+	go detectShadow(defaultShadowInterval)
+	go detectSynthetics(defaultSyntheticInterval)
+
+	replicas = membership.New(membership.NewEtcdRegistry(store.Kapi), self, ttl)
+	go replicas.Run(onReplicasChange)
+
+	data, _ := etcdutils.Encode(self)
+	elector = election.New(election.NewEtcdLocker(store.Kapi), models.LeaderKey, string(data), ttl)
+	go elector.Run(nil)
+}
+
+// IsLeader reports whether this replica is the leader
+func IsLeader() bool {
+	return elector != nil && elector.IsLeader()
+}
+
+// Leader returns the leader replica, nil while there is no leader
+func Leader() (*models.Manager, error) {
+	v, err := elector.Leader()
+	if err != nil || v == "" {
+		return nil, err
+	}
+	leader := new(models.Manager)
+	if err := etcdutils.Decode(v, leader); err != nil {
+		return nil, err
+	}
+	return leader, nil
+}
+
+// addJob put the job into taskQ and schedule it while this replica owns it,
+// the old job of the same key is cancelled
+func addJob(key string, job *HealthJob) {
+	taskQMutex.Lock()
+	defer taskQMutex.Unlock()
+	taskQ[key] = job
+	if sched == nil {
+		return
+	}
+	if owns(key) {
+		sched.add(job)
+	} else {
+		sched.remove(key)
+	}
+}
+
+// removeJob remove the job from taskQ and cancel it
+func removeJob(key string) {
+	taskQMutex.Lock()
+	defer taskQMutex.Unlock()
+	delete(taskQ, key)
+	if sched != nil {
+		sched.remove(key)
+	}
+}
+
+// touchJob reschedule the job after its policy changed
+func touchJob(key string) {
+	taskQMutex.RLock()
+	defer taskQMutex.RUnlock()
+	if sched != nil {
+		sched.touch(key)
+	}
 }
 
 // "/clusters/{clusterID}/{instanceID}"
@@ -58,45 +159,150 @@ func isInstanceKey(key string) bool {
 	return false
 }
 
-func initTaskQ(kapi client.KeysAPI) error {
-	resp, err := kapi.Get(context.Background(), configs.ClustersKey, nil)
+// "/clusters/{clusterID}/option"
+func isOptionKey(key string) bool {
+	ks := strings.Split(key, "/")
+	return len(ks) == 4 && ks[3] == configs.ClusterOptionsKey
+}
+
+// "/clusters/{clusterID}"
+func isClusterKey(key string) bool {
+	return len(strings.Split(key, "/")) == 3
+}
+
+// clusterIDOf returns the clusterID in cluster, option or instance key
+func clusterIDOf(key string) string {
+	return strings.Split(key, "/")[2]
+}
+
+// initTaskQ load the jobs of stored instances, returns the index
+// they're loaded at
+func initTaskQ(kapi client.KeysAPI) (uint64, error) {
+	report, err := reconcile(kapi)
 	if err != nil {
-		return err
-	} else if !resp.Node.Dir {
-		return errors.New("configs.ClustersKey is not a dir")
-	}
-
-	for _, clusterNode := range resp.Node.Nodes {
-		// clusterID := strings.Split(clusterNode.Key, "/")[2]
-		clsOpt := new(models.ClusterOption)
-		if resp2, err := kapi.Get(context.Background(), clusterNode.Key, nil); err == nil && resp2.Node.Dir {
-			for _, srvInsNode := range resp2.Node.Nodes {
-				// skip the option node
-				if strings.Split(srvInsNode.Key, "/")[3] == configs.ClusterOptionsKey {
-					if err := etcdutils.Decode(srvInsNode.Value, clsOpt); err != nil {
-						logger.Logger.Error(err)
-					}
-					continue
-				}
+		return 0, err
+	}
+	return report.Index, nil
+}
 
-				srvInsCfg := new(models.ServerInstance)
-				if err := etcdutils.Decode(srvInsNode.Value, srvInsCfg); err != nil {
-					logger.Logger.Error(err)
-					continue
-				}
+// setClusterOption decode and cache the cluster option
+func setClusterOption(clusterID, v string) *models.ClusterOption {
+	clsOpt := new(models.ClusterOption)
+	if err := etcdutils.Decode(v, clsOpt); err != nil {
+		logger.Logger.Errorf("etcdutils.Decode(v, clsOpt) failed: err %v, v=[%s]", err, v)
+		return nil
+	}
 
-				// if need check health of server instance
-				if srvInsCfg.NeedCheckHealth {
-					taskQ[srvInsNode.Key] = newHealthJob(srvInsCfg.HealthCheckURL, srvInsNode.Key, HealthCheckTTL)
-				}
-			}
-		}
+	clusterOptsMutex.Lock()
+	clusterOpts[clusterID] = clsOpt
+	clusterOptsMutex.Unlock()
+	return clsOpt
+}
+
+// clusterPolicy returns the health check defaults of the cluster, maybe nil
+func clusterPolicy(clusterID string) *models.HealthCheckPolicy {
+	clusterOptsMutex.RLock()
+	defer clusterOptsMutex.RUnlock()
+
+	if clsOpt, ok := clusterOpts[clusterID]; ok {
+		return clsOpt.HealthCheck
 	}
 	return nil
 }
 
+// newInstanceJob create a health job of the instance, the state is
+// inherited from the old job if there is one, or loaded from the store
+func newInstanceJob(key string, ins *models.ServerInstance, old *HealthJob) *HealthJob {
+	job := newHealthJob(ins.HealthCheckURL, key)
+	job.InstanceAddr = ins.Addr
+	job.setWeight(ins.Weight)
+	job.setPolicy(ins.HealthCheck, clusterPolicy(clusterIDOf(key)))
+	job.setHolds(ins.HealthOverride, ins.HealthPause, clusterPause(clusterIDOf(key)))
+
+	if old != nil {
+		job.inherit(old)
+		return job
+	}
+	if status := loadHealthStatus(key); status != nil {
+		job.restore(status)
+	}
+	return job
+}
+
+// loadHealthStatus load the health status of the instance or the
+// synthetic probe, maybe nil
+func loadHealthStatus(key string) *models.HealthStatus {
+	v, err := store.Get(statusKeyOf(key))
+	if err != nil {
+		return nil
+	}
+
+	status := new(models.HealthStatus)
+	if err := etcdutils.Decode(v, status); err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	return status
+}
+
 func clusterWatchCallback(op etcdutils.OpCode, key, v string) {
 	// logger.Logger.Infof("op: %d, key: %s", op, key)
+	lastWatchEvent.Store(time.Now())
+	if op == etcdutils.DeleteOp && isClusterKey(key) {
+		// the whole cluster has been deleted
+		clusterOptsMutex.Lock()
+		delete(clusterOpts, clusterIDOf(key))
+		clusterOptsMutex.Unlock()
+
+		taskQMutex.RLock()
+		var jobKeys []string
+		for jobKey := range taskQ {
+			if strings.HasPrefix(jobKey, key+"/") {
+				jobKeys = append(jobKeys, jobKey)
+			}
+		}
+		taskQMutex.RUnlock()
+		for _, jobKey := range jobKeys {
+			removeJob(jobKey)
+		}
+		return
+	}
+
+	if op == etcdutils.SetOp && isOptionKey(key) {
+		oldShadow := clusterShadow(clusterIDOf(key))
+		clsOpt := setClusterOption(clusterIDOf(key), v)
+		if clsOpt == nil {
+			return
+		}
+
+		// apply the new defaults and pause to jobs in the cluster
+		prefix := configs.ClustersKey + clusterIDOf(key) + "/"
+		jobs := make(map[string]*HealthJob)
+		taskQMutex.RLock()
+		for jobKey, job := range taskQ {
+			if strings.HasPrefix(jobKey, prefix) {
+				job.mutex.Lock()
+				insPolicy := job.insPolicy
+				job.mutex.Unlock()
+				job.setPolicy(insPolicy, clsOpt.HealthCheck)
+				job.setClusterPause(clsOpt.HealthPause)
+				if sched != nil {
+					sched.touch(jobKey)
+				}
+				jobs[jobKey] = job
+			}
+		}
+		taskQMutex.RUnlock()
+
+		// entering or leaving the shadow mode changes where the status goes
+		if !sameShadow(oldShadow, clsOpt.HealthShadow) {
+			for jobKey, job := range jobs {
+				pushIfEffectiveChanged(jobKey, job)
+			}
+		}
+		return
+	}
+
 	if !isInstanceKey(key) {
 		return
 	}
@@ -109,81 +315,70 @@ func clusterWatchCallback(op etcdutils.OpCode, key, v string) {
 			return
 		}
 
+		if !instance.NeedCheckHealth {
+			removeJob(key)
+			return
+		}
+
 		taskQMutex.RLock()
 		job, ok := taskQ[key]
 		taskQMutex.RUnlock()
 
-		// existed and addr has no changed, skip this
-		if ok {
-			if job.TargetURL == instance.HealthCheckURL {
-				return
-			}
-			if !instance.NeedCheckHealth {
-				taskQMutex.Lock()
-				delete(taskQ, key)
-				taskQMutex.Unlock()
-				return
-			}
+		// existed and addr has no changed, only update the policy and holds
+		if ok && job.TargetURL == instance.HealthCheckURL && job.InstanceAddr == instance.Addr {
+			job.setWeight(instance.Weight)
+			job.setPolicy(instance.HealthCheck, clusterPolicy(clusterIDOf(key)))
+			job.setHolds(instance.HealthOverride, instance.HealthPause, clusterPause(clusterIDOf(key)))
+			touchJob(key)
+			pushIfEffectiveChanged(key, job)
+			return
 		}
-		// else set the key with new value
-		taskQMutex.Lock()
-		taskQ[key] = newHealthJob(instance.HealthCheckURL, key, HealthCheckTTL)
-		taskQMutex.Unlock()
-	case etcdutils.DeleteOp:
-		taskQMutex.Lock()
-		delete(taskQ, key)
-		taskQMutex.Unlock()
+		// else replace the job, the old one is cancelled
+		newJob := newInstanceJob(key, instance, job)
+		addJob(key, newJob)
+		pushIfEffectiveChanged(key, newJob)
+	case etcdutils.DeleteOp, etcdutils.ExpireOp:
+		removeJob(key)
 	default:
 		return
 	}
 }
 
-func healthChecking(store *etcdutils.EtcdStore, pool *chanCheckerPool) {
-	chanCheckResult := make(chan checkResult, 100)
+// healthChecking handle the check results from the scheduler
+func healthChecking(results <-chan checkResult) {
+	go writer.run()
+	go shadowWriter.run()
 
-	go func() {
-		for {
-			select {
-			case cr := <-chanCheckResult:
-				v, err := store.Get(cr.Key)
-				if err != nil {
-					logger.Logger.Errorf("healthChecking() kapi.Get(context.Background(), cr.Key, nil) got err: %v", err)
-					continue
-				}
+	for cr := range results {
+		handleResult(cr, writer)
+	}
+}
 
-				ins := new(models.ServerInstance)
-				if err := etcdutils.Decode(v, ins); err != nil {
-					logger.Logger.Errorf("healthChecking() kapi.Get(context.Background(), cr.Key, nil) got err: %v", err)
-					continue
-				}
-				// TODO: ignore no need change
-				// no change do not set again
-				// if ins.IsAlive == cr.IsAlive {
-				// 	return
-				// }
-				ins.IsAlive = cr.IsAlive
-				data, _ := etcdutils.Encode(ins)
-				if err := store.Set(cr.Key, string(data), -1); err != nil {
-					logger.Logger.Errorf("healthChecking() kapi.Set(context.Background(), cr.Key, string(data), nil) got err: %v", err)
-					continue
-				}
-			default:
-				time.Sleep(100 * time.Millisecond)
-			}
-		}
-	}()
+// handleResult feed the check result into its job, and queue the health
+// status to be written only while the state, flapping or effective state
+// of the instance changed
+func handleResult(cr checkResult, writer *statusWriter) {
+	// the job has been removed or replaced while checking
+	taskQMutex.RLock()
+	job, ok := taskQ[cr.Key]
+	taskQMutex.RUnlock()
+	if !ok || (cr.job != nil && cr.job != job) {
+		return
+	}
 
-	for {
-		taskQMutex.RLock()
-		for _, job := range taskQ {
-			checker, err := pool.Get()
-			if err != nil {
-				logger.Logger.Errorf("healthChecking() pool.Get() got err: %v", err)
-				continue
-			}
-			go checker.Check(job, chanCheckResult)
-		}
-		taskQMutex.RUnlock()
-		time.Sleep(100 * time.Millisecond)
+	job.record(cr)
+	// the alive results are held while gateways report the instance failing
+	changed := false
+	if !cr.IsAlive || !job.passiveFailing() {
+		changed = job.observe(cr.IsAlive)
+	}
+	if changed {
+		job.transit(cr)
+	}
+	flapChanged := job.updateFlapping(cr.CheckTime)
+	// the expired health override changes the effective state too
+	if !changed && !flapChanged && !job.effectiveChanged(cr.CheckTime) {
+		return
 	}
+	pushStatus(writer, job, cr)
 }
@@ -0,0 +1,131 @@
+package healthchecking
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// overdueLag is how late a job could be before it's overdue
+const overdueLag = time.Second
+
+// lastWatchEvent is the time of the latest cluster watch event
+var lastWatchEvent atomic.Value
+
+// JobInfo is the state of a job in taskQ
+type JobInfo struct {
+	InstanceKey string              `json:"instance_key"`
+	TargetURL   string              `json:"target_url"`
+	Type        models.CheckType    `json:"type"`
+	Owned       bool                `json:"owned"`              // this replica should check the job
+	Scheduled   bool                `json:"scheduled"`          // the job is in the scheduler of this replica
+	InFlight    bool                `json:"in_flight"`          // the job is being checked
+	NextRun     *time.Time          `json:"next_run,omitempty"` // nil while in flight or not scheduled
+	PausedUntil *time.Time          `json:"paused_until,omitempty"`
+	Overdue     bool                `json:"overdue"` // see Introspect
+	IsAlive     bool                `json:"is_alive"`
+	Known       bool                `json:"known"` // the state has been known
+	LastResult  *models.ProbeResult `json:"last_result,omitempty"`
+}
+
+// Introspection is the state of the health checker on this replica
+type Introspection struct {
+	Jobs           []*JobInfo       `json:"jobs"`
+	TotalJobs      int              `json:"total_jobs"`     // count of jobs in taskQ
+	ScheduledJobs  int              `json:"scheduled_jobs"` // count of jobs checked by this replica
+	InFlight       int              `json:"in_flight"`
+	Overdue        int              `json:"overdue"`
+	Workers        int              `json:"workers"`
+	PoolIdle       int              `json:"pool_idle"` // idle checkers in the pool
+	PoolCap        int              `json:"pool_cap"`
+	ResultQueue    int              `json:"result_queue"` // results waiting to be handled
+	ResultQueueCap int              `json:"result_queue_cap"`
+	LastWatchEvent *time.Time       `json:"last_watch_event,omitempty"`
+	WatchIndex     uint64           `json:"watch_index"` // etcd index the clusters are watched after
+	LastReconcile  *ReconcileReport `json:"last_reconcile,omitempty"`
+}
+
+// Introspect returns the state of the health checker. a job is overdue
+// while it's late by overdueLag, in flight longer than its timeout and
+// overdueLag, or owned but not scheduled. jobs are sorted by key,
+// only the overdue ones are returned while overdueOnly is set.
+func Introspect(overdueOnly bool) *Introspection {
+	now := time.Now()
+	in := &Introspection{
+		Jobs:    make([]*JobInfo, 0),
+		Workers: defaultWorkers,
+	}
+	if checkerPool != nil {
+		in.PoolIdle, in.PoolCap = checkerPool.Len(), checkerPool.Cap()
+	}
+	if t, ok := lastWatchEvent.Load().(time.Time); ok {
+		in.LastWatchEvent = &t
+	}
+	if clusterWatcher != nil {
+		in.WatchIndex = clusterWatcher.Index()
+	}
+	in.LastReconcile = LastReconcile()
+
+	taskQMutex.RLock()
+	var entries map[string]entryInfo
+	if sched != nil {
+		entries = sched.snapshot()
+		in.ResultQueue, in.ResultQueueCap = sched.pending()
+	}
+	jobs := make(map[string]*HealthJob, len(taskQ))
+	owned := make(map[string]bool, len(taskQ))
+	for key, job := range taskQ {
+		jobs[key] = job
+		owned[key] = owns(key)
+	}
+	taskQMutex.RUnlock()
+
+	in.TotalJobs = len(jobs)
+	for key, job := range jobs {
+		policy, _ := job.getPolicy()
+		isAlive, known := job.state()
+		info := &JobInfo{
+			InstanceKey: key,
+			TargetURL:   job.TargetURL,
+			Type:        policy.Type,
+			Owned:       owned[key],
+			IsAlive:     isAlive,
+			Known:       known,
+			LastResult:  job.lastProbe(),
+		}
+		if until := job.pausedUntil(now); !until.IsZero() {
+			info.PausedUntil = &until
+		}
+
+		entry, scheduled := entries[key]
+		info.Scheduled = scheduled
+		switch {
+		case !scheduled:
+			info.Overdue = info.Owned
+		case entry.running:
+			info.InFlight = true
+			info.Overdue = now.Sub(entry.started) > policy.Timeout.Std()+overdueLag
+		default:
+			next := entry.next
+			info.NextRun = &next
+			info.Overdue = info.PausedUntil == nil && now.Sub(next) > overdueLag
+		}
+
+		if scheduled {
+			in.ScheduledJobs++
+		}
+		if info.InFlight {
+			in.InFlight++
+		}
+		if info.Overdue {
+			in.Overdue++
+		}
+		if !overdueOnly || info.Overdue {
+			in.Jobs = append(in.Jobs, info)
+		}
+	}
+	sort.Slice(in.Jobs, func(i, j int) bool { return in.Jobs[i].InstanceKey < in.Jobs[j].InstanceKey })
+	return in
+}
@@ -0,0 +1,42 @@
+package healthchecking
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Introspect(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	ring = nil
+	sched = newScheduler(1, nil, make(chan checkResult, 10))
+	sched.rand = func() float64 { return 0 }
+	defer func() { sched = nil }()
+
+	late := newIntervalJob("/clusters/1/1", time.Minute)
+	fresh := newIntervalJob("/clusters/1/2", time.Minute)
+	lost := newIntervalJob("/clusters/1/3", time.Minute)
+	addJob(late.InstanceKey, late)
+	addJob(fresh.InstanceKey, fresh)
+	taskQ[lost.InstanceKey] = lost
+
+	// the scheduler is not running, so late is never dispatched
+	sched.mutex.Lock()
+	sched.entries[late.InstanceKey].next = time.Now().Add(-time.Minute)
+	sched.mutex.Unlock()
+
+	in := Introspect(false)
+	if in.TotalJobs != 3 || in.ScheduledJobs != 2 || in.Overdue != 2 {
+		t.Fatalf("want 3 jobs, 2 scheduled and 2 overdue, got: %+v", in)
+	}
+	if in.ResultQueueCap != 10 {
+		t.Errorf("want result queue cap 10, got: %d", in.ResultQueueCap)
+	}
+
+	in = Introspect(true)
+	if len(in.Jobs) != 2 || in.Jobs[0].InstanceKey != late.InstanceKey || in.Jobs[1].InstanceKey != lost.InstanceKey {
+		t.Fatalf("want late and lost jobs overdue, got: %+v", in.Jobs)
+	}
+	if in.Jobs[0].NextRun == nil || in.Jobs[1].Scheduled {
+		t.Errorf("want late scheduled and lost not, got: %+v %+v", in.Jobs[0], in.Jobs[1])
+	}
+}
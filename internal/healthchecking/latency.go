@@ -0,0 +1,190 @@
+package healthchecking
+
+import (
+	"context"
+	"math"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+const (
+	defaultOutlierInterval = 30 * time.Second      // interval to detect latency outliers
+	minLatencySamples      = 5                     // successful probes needed for the latency stats
+	minClusterInstances    = 3                     // instances with latency stats needed for the cluster median
+	minDegradedGap         = 10 * time.Millisecond // a degraded instance is slower than the median by this at least
+	recoverRatio           = 0.8                   // a degraded instance recovers below recoverRatio of the degraded bound
+	latencyDrift           = 0.2                   // the status is written while p50 drifts by this fraction
+)
+
+// latencyStatsLocked returns the latency percentiles of the successful
+// probes in the ring, nil while there are too few
+func (job *HealthJob) latencyStatsLocked() *models.LatencyStats {
+	latencies := make([]time.Duration, 0, len(job.probes))
+	for _, probe := range job.probes {
+		if probe.IsAlive {
+			latencies = append(latencies, probe.Latency.Std())
+		}
+	}
+	if len(latencies) < minLatencySamples {
+		return nil
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &models.LatencyStats{
+		P50:     models.Duration(percentile(latencies, 0.5)),
+		P90:     models.Duration(percentile(latencies, 0.9)),
+		P99:     models.Duration(percentile(latencies, 0.99)),
+		Samples: len(latencies),
+	}
+}
+
+// percentile returns the nearest rank percentile p of the sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// setWeight set the weight of the instance
+func (job *HealthJob) setWeight(weight int) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	job.weight = weight
+}
+
+// effectiveWeightLocked returns the reduced weight while degraded,
+// 0 means the instance weight
+func (job *HealthJob) effectiveWeightLocked() int {
+	if !job.degraded || job.policy == nil || job.policy.DegradedWeight == 0 {
+		return 0
+	}
+	weight := job.weight * job.policy.DegradedWeight / 100
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+// updateDegraded mark the job degraded while its median latency is above
+// DegradedFactor times the cluster median, it recovers only below
+// recoverRatio of that bound, so it does not flip around the bound.
+// returns true while the status should be written, the degraded flag
+// changed or the latency drifted from the one written.
+func (job *HealthJob) updateDegraded(median time.Duration) bool {
+	policy, _ := job.getPolicy()
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	stats := job.latencyStatsLocked()
+	degraded := false
+	if stats != nil && job.isAlive && median > 0 {
+		p50, bound := stats.P50.Std(), time.Duration(policy.DegradedFactor*float64(median))
+		if job.degraded {
+			degraded = float64(p50) >= recoverRatio*float64(bound)
+		} else {
+			degraded = p50 > bound && p50-median >= minDegradedGap
+		}
+	}
+	changed := degraded != job.degraded
+	job.degraded = degraded
+
+	if stats == nil {
+		return changed
+	}
+	drift := math.Abs(float64(stats.P50-job.pushedP50)) >= latencyDrift*float64(job.pushedP50)
+	return changed || job.pushedP50 == 0 || drift
+}
+
+// detectOutliers check the latency of jobs every interval, it never returns
+func detectOutliers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		checkOutliers(now)
+	}
+}
+
+// checkOutliers update the degraded flag of the jobs checked by this
+// replica against the median latency of their clusters, the latency of
+// instances checked by other replicas is read from their health status
+func checkOutliers(now time.Time) {
+	byCluster := make(map[string][]*HealthJob)
+	taskQMutex.RLock()
+	for key, job := range taskQ {
+		if sched != nil && sched.has(key) && !isSyntheticKey(key) {
+			byCluster[clusterIDOf(key)] = append(byCluster[clusterIDOf(key)], job)
+		}
+	}
+	taskQMutex.RUnlock()
+
+	for clusterID, jobs := range byCluster {
+		p50s := loadClusterP50s(clusterID)
+		for _, job := range jobs {
+			job.mutex.Lock()
+			stats := job.latencyStatsLocked()
+			if stats != nil && job.isAlive {
+				p50s[job.InstanceKey] = stats.P50.Std()
+			} else {
+				delete(p50s, job.InstanceKey)
+			}
+			job.mutex.Unlock()
+		}
+		median := medianOf(p50s)
+
+		for _, job := range jobs {
+			if job.updateDegraded(median) {
+				pushStatus(writer, job, job.latestResult(now))
+			}
+		}
+	}
+}
+
+// medianOf returns the median of p50s, 0 while there are too few
+func medianOf(p50s map[string]time.Duration) time.Duration {
+	if len(p50s) < minClusterInstances {
+		return 0
+	}
+	latencies := make([]time.Duration, 0, len(p50s))
+	for _, p50 := range p50s {
+		latencies = append(latencies, p50)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return percentile(latencies, 0.5)
+}
+
+// loadClusterP50s load the median latency of alive instances in the
+// cluster from their health status, the map key is the instance key
+func loadClusterP50s(clusterID string) map[string]time.Duration {
+	p50s := make(map[string]time.Duration)
+	if store == nil {
+		return p50s
+	}
+	resp, err := store.Kapi.Get(context.Background(), utils.Fstring("%s%s", models.HealthKey, clusterID), nil)
+	if err != nil {
+		logger.Logger.Errorf("loadClusterP50s(%s) got err: %v", clusterID, err)
+		return p50s
+	}
+
+	for _, node := range resp.Node.Nodes {
+		status := new(models.HealthStatus)
+		if err := etcdutils.Decode(node.Value, status); err != nil {
+			continue
+		}
+		if status.IsAlive && status.Latency != nil {
+			p50s[configs.ClustersKey+clusterID+"/"+path.Base(node.Key)] = status.Latency.P50.Std()
+		}
+	}
+	return p50s
+}
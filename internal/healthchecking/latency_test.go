@@ -0,0 +1,68 @@
+package healthchecking
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_LatencyStats(t *testing.T) {
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	for i := 1; i <= 10; i++ {
+		job.record(checkResult{IsAlive: true, Latency: time.Duration(i) * time.Millisecond})
+	}
+	job.record(checkResult{IsAlive: false, Latency: time.Second})
+
+	job.mutex.Lock()
+	stats := job.latencyStatsLocked()
+	job.mutex.Unlock()
+	if stats.Samples != 10 || stats.P50.Std() != 5*time.Millisecond || stats.P99.Std() != 10*time.Millisecond {
+		t.Errorf("want failed probes excluded, got: %+v", stats)
+	}
+}
+
+func Test_CheckOutliers(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	ring = nil
+	sched = newScheduler(1, nil, nil)
+	store := new(countingStore)
+	writer = newStatusWriter(store, time.Hour, 100)
+	defer func() { sched, writer = nil, nil }()
+
+	latencies := []time.Duration{20, 22, 25, 200}
+	var jobs []*HealthJob
+	for idx, latency := range latencies {
+		job := newHealthJob("http://127.0.0.1:9091/health", fmt.Sprintf("/clusters/1/%d", idx))
+		job.setWeight(10)
+		job.setPolicy(&models.HealthCheckPolicy{DegradedWeight: 50}, nil)
+		job.setState(true)
+		for i := 0; i < minLatencySamples; i++ {
+			job.record(checkResult{IsAlive: true, Latency: latency * time.Millisecond})
+		}
+		addJob(job.InstanceKey, job)
+		jobs = append(jobs, job)
+	}
+
+	checkOutliers(time.Now())
+	writer.flush()
+	status := new(models.HealthStatus)
+	etcdutils.Decode(store.data[models.HealthStatusKeyOf(jobs[3].InstanceKey)], status)
+	if !status.Degraded || status.Weight != 5 || status.Latency.P50.Std() != 200*time.Millisecond {
+		t.Errorf("want the slow instance degraded with half weight, got: %+v", status)
+	}
+	status = new(models.HealthStatus)
+	etcdutils.Decode(store.data[models.HealthStatusKeyOf(jobs[0].InstanceKey)], status)
+	if status.Degraded || status.Weight != 0 {
+		t.Errorf("want the fast instance not degraded, got: %+v", status)
+	}
+
+	// nothing changed, nothing written
+	sets := store.sets
+	checkOutliers(time.Now())
+	if writer.flush(); store.sets != sets {
+		t.Errorf("want nothing written without change, got: %d", store.sets-sets)
+	}
+}
@@ -0,0 +1,102 @@
+package healthchecking
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// maxMirrorRetries is the times to retry the mirror while the instance
+// is updated concurrently
+const maxMirrorRetries = 3
+
+// mirrorInstance write the effective state of status into the instance
+// value under /clusters/, which is what gateways route by. it's written
+// only while it differs, and by compare-and-swap so a concurrent update of
+// the instance is never overwritten. deleted instances and synthetic probes
+// are skipped.
+func mirrorInstance(kapi client.KeysAPI, instanceKey string, status *models.HealthStatus) error {
+	if !isInstanceKey(instanceKey) {
+		return nil
+	}
+
+	for retry := 0; retry < maxMirrorRetries; retry++ {
+		resp, err := kapi.Get(context.Background(), instanceKey, nil)
+		if err != nil {
+			if isErrorCode(err, client.ErrorCodeKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		ins := new(models.ServerInstance)
+		if err := etcdutils.Decode(resp.Node.Value, ins); err != nil {
+			return err
+		}
+		if !ins.ApplyHealth(status) {
+			return nil
+		}
+
+		data, err := etcdutils.Encode(ins)
+		if err != nil {
+			return err
+		}
+		opts := &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex}
+		if _, err = kapi.Set(context.Background(), instanceKey, string(data), opts); err == nil ||
+			!isErrorCode(err, client.ErrorCodeTestFailed) {
+			return err
+		}
+	}
+	return fmt.Errorf("instance %s is updated concurrently", instanceKey)
+}
+
+// mirrorDrifted mirror the effective state queued of the job checked by
+// this replica again while the instance value misses it, returns whether
+// it's mirrored
+func mirrorDrifted(key string, job *HealthJob, ins *models.ServerInstance) bool {
+	taskQMutex.RLock()
+	checking := sched != nil && sched.has(key)
+	taskQMutex.RUnlock()
+	if !checking || writer == nil || writer.mirror == nil {
+		return false
+	}
+
+	job.mutex.Lock()
+	pushed := job.pushed
+	job.mutex.Unlock()
+	status := &models.HealthStatus{IsAlive: pushed.isAlive}
+	copied := *ins
+	if !pushed.known || !copied.ApplyHealth(status) {
+		return false
+	}
+	if err := writer.mirror(key, status); err != nil {
+		logger.Logger.Errorf("healthchecking mirror instance[%s] got err: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// mirrorUnchecked mirror the effective state of the instance not health
+// checked again while it's changed by the health override expired, by the
+// replica owning the key only. returns whether it's mirrored
+func mirrorUnchecked(key string, ins *models.ServerInstance) bool {
+	taskQMutex.RLock()
+	owned := owns(key)
+	taskQMutex.RUnlock()
+	if !owned || writer == nil || writer.mirror == nil {
+		return false
+	}
+
+	copied := *ins
+	if !copied.ApplyHealth(nil) {
+		return false
+	}
+	if err := writer.mirror(key, nil); err != nil {
+		logger.Logger.Errorf("healthchecking mirror instance[%s] got err: %v", key, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package healthchecking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// storedAlive returns IsAlive of the instance value gateways read
+func storedAlive(t *testing.T, kapi *memKeysAPI, key string) bool {
+	resp, err := kapi.Get(context.Background(), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins := new(models.ServerInstance)
+	etcdutils.Decode(resp.Node.Value, ins)
+	return ins.IsAlive
+}
+
+func Test_StatusWriterMirror(t *testing.T) {
+	kapi := newMemKeysAPI()
+	setInstance(kapi, "/clusters/1/a", "http://127.0.0.1:8080/health", nil)
+	statuses := new(countingStore)
+	w := newStatusWriter(statuses, time.Hour, 100)
+	w.mirror = func(instanceKey string, status *models.HealthStatus) error {
+		return mirrorInstance(kapi, instanceKey, status)
+	}
+
+	w.push("/clusters/1/a", &models.HealthStatus{IsAlive: true})
+	w.flush()
+	if !storedAlive(t, kapi, "/clusters/1/a") {
+		t.Fatalf("want the instance value marked alive")
+	}
+	index := kapi.index
+	w.push("/clusters/1/a", &models.HealthStatus{IsAlive: true, LastError: "slow"})
+	w.flush()
+	if kapi.index != index {
+		t.Errorf("want the instance value not written without change")
+	}
+
+	w.push("/clusters/1/a", &models.HealthStatus{IsAlive: false})
+	w.flush()
+	if storedAlive(t, kapi, "/clusters/1/a") {
+		t.Errorf("want the instance value marked dead")
+	}
+
+	// deleted instances and synthetic probes are not mirrored
+	kapi.Delete(context.Background(), "/clusters/1/a", nil)
+	w.push("/clusters/1/a", &models.HealthStatus{IsAlive: true})
+	w.push(models.SyntheticKey("p1"), &models.HealthStatus{IsAlive: true})
+	if n := w.flush(); n != 2 {
+		t.Errorf("want 2 status written, got: %d", n)
+	}
+	if _, err := kapi.Get(context.Background(), "/clusters/1/a", nil); err == nil {
+		t.Errorf("want the deleted instance not written again")
+	}
+}
+
+func Test_ReconcileMirror(t *testing.T) {
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob)
+	clusterOpts = make(map[string]*models.ClusterOption)
+	ring = nil
+	sched = newScheduler(1, nil, nil)
+	writer = newStatusWriter(new(countingStore), time.Hour, 100)
+	writer.mirror = func(instanceKey string, status *models.HealthStatus) error {
+		return mirrorInstance(kapi, instanceKey, status)
+	}
+	defer func() { store, sched, writer = nil, nil, nil }()
+
+	setInstance(kapi, "/clusters/1/a", "http://127.0.0.1:8080/health", nil)
+	if _, err := initTaskQ(kapi); err != nil {
+		t.Fatal(err)
+	}
+	job := jobOf("/clusters/1/a")
+	sched.add(job)
+	job.setState(true)
+	job.mutex.Lock()
+	job.pushed = effective{isAlive: true, known: true}
+	job.mutex.Unlock()
+
+	// the instance value missed the state queued
+	report, err := reconcile(kapi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mirrored) != 1 || !storedAlive(t, kapi, "/clusters/1/a") {
+		t.Fatalf("want the instance value mirrored, got: %+v", report)
+	}
+	if report, _ = reconcile(kapi); report.Drifted() {
+		t.Errorf("want no drift, got: %+v", report)
+	}
+}
+
+func Test_ReconcileOverrideExpired(t *testing.T) {
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob)
+	clusterOpts = make(map[string]*models.ClusterOption)
+	ring = nil
+	sched = newScheduler(1, nil, nil)
+	writer = newStatusWriter(new(countingStore), time.Hour, 100)
+	writer.mirror = func(instanceKey string, status *models.HealthStatus) error {
+		return mirrorInstance(kapi, instanceKey, status)
+	}
+	defer func() { store, sched, writer = nil, nil, nil }()
+
+	// the overrides expired, the checked instance is paused so it's not probed
+	setExpired := func(key string, needCheck bool) {
+		ins := &models.ServerInstance{
+			ServerInstance: cmodels.ServerInstance{Addr: "127.0.0.1:8080", HealthCheckURL: "http://127.0.0.1:8080/health", NeedCheckHealth: needCheck},
+			AdminState:     models.AdminStateEnabled,
+			HealthOverride: &models.HealthOverride{IsAlive: false, Until: time.Now().Add(-time.Second)},
+			HealthPause:    &models.HealthPause{Until: time.Now().Add(time.Hour)},
+		}
+		v, _ := etcdutils.Encode(ins)
+		kapi.Set(context.Background(), key, string(v), nil)
+	}
+	setExpired("/clusters/1/a", true)
+	if _, err := initTaskQ(kapi); err != nil {
+		t.Fatal(err)
+	}
+	setExpired("/clusters/1/b", false)
+	job := jobOf("/clusters/1/a")
+	sched.add(job)
+	job.setState(true)
+	job.mutex.Lock()
+	job.pushed = effective{isAlive: false, known: true, overridden: true}
+	job.mutex.Unlock()
+
+	report, err := reconcile(kapi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.mutex.Lock()
+	pushed := job.pushed
+	job.mutex.Unlock()
+	if pushed != (effective{isAlive: true, known: true}) {
+		t.Errorf("want the checked state pushed after the override expired, got: %+v", pushed)
+	}
+	if len(report.Mirrored) != 2 || !storedAlive(t, kapi, "/clusters/1/a") || !storedAlive(t, kapi, "/clusters/1/b") {
+		t.Fatalf("want both instance values alive, got: %+v", report)
+	}
+	if report, _ = reconcile(kapi); report.Drifted() {
+		t.Errorf("want no drift, got: %+v", report)
+	}
+}
+
+func Test_MirrorAdminState(t *testing.T) {
+	kapi := newMemKeysAPI()
+	ins := &models.ServerInstance{
+		ServerInstance: cmodels.ServerInstance{Addr: "127.0.0.1:8080", NeedCheckHealth: true, IsAlive: true},
+		AdminState:     models.AdminStateDisabled,
+	}
+	v, _ := etcdutils.Encode(ins)
+	kapi.Set(context.Background(), "/clusters/1/a", string(v), nil)
+
+	// the instance out of rotation is never alive in what gateways read
+	if err := mirrorInstance(kapi, "/clusters/1/a", &models.HealthStatus{IsAlive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if storedAlive(t, kapi, "/clusters/1/a") {
+		t.Fatalf("want the disabled instance value not alive")
+	}
+
+	ins.AdminState = models.AdminStateEnabled
+	ins.IsAlive = false
+	v, _ = etcdutils.Encode(ins)
+	kapi.Set(context.Background(), "/clusters/1/a", string(v), nil)
+	if err := mirrorInstance(kapi, "/clusters/1/a", &models.HealthStatus{IsAlive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !storedAlive(t, kapi, "/clusters/1/a") {
+		t.Errorf("want the enabled instance value alive")
+	}
+}
@@ -0,0 +1,103 @@
+package healthchecking
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+var errNotHealthChecked = errors.New("instance is not health checked")
+
+// CheckReport is the result of an on-demand check
+type CheckReport struct {
+	Type      models.CheckType     `json:"type"`
+	Target    string               `json:"target"` // url of http checks, host:port of others
+	Probe     *models.ProbeResult  `json:"probe"`
+	Persisted bool                 `json:"persisted"`
+	Health    *models.HealthStatus `json:"health,omitempty"` // the status written while persisted
+}
+
+// CheckNow probe the instance at once with its policy, the result is not
+// fed into the rise and fall thresholds. while persist is set, the result
+// becomes the state of the instance at once and the status is written,
+// which is allowed only on the replica checking the instance.
+func CheckNow(clusterID, instanceID string, persist bool) (*CheckReport, error) {
+	key := utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
+	v, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	ins := new(models.ServerInstance)
+	if err := etcdutils.Decode(v, ins); err != nil {
+		return nil, err
+	}
+
+	taskQMutex.RLock()
+	job, ok := taskQ[key]
+	checking := ok && sched.has(key)
+	owner := ""
+	if ring != nil {
+		owner = ring.Owner(key)
+	}
+	taskQMutex.RUnlock()
+
+	if persist && !ok {
+		return nil, errNotHealthChecked
+	}
+	if persist && !checking {
+		return nil, fmt.Errorf("instance is checked by replica %s, persist there", owner)
+	}
+	if persist && shadowOf(key, time.Now()) != nil {
+		return nil, errors.New("instance is in shadow mode, its health status is not written")
+	}
+	if !ok {
+		// probe with the policy though the instance is not checked
+		job = newHealthJob(ins.HealthCheckURL, key)
+		job.InstanceAddr = ins.Addr
+		job.setPolicy(ins.HealthCheck, clusterPolicy(clusterID))
+	}
+
+	checker, err := checkerPool.Get()
+	if err != nil {
+		return nil, err
+	}
+	cr := checker.probe(job)
+	checkerPool.Put(checker)
+
+	policy, _ := job.getPolicy()
+	report := &CheckReport{
+		Type:   policy.Type,
+		Target: job.TargetURL,
+		Probe: &models.ProbeResult{
+			Time:       cr.CheckTime,
+			Latency:    models.Duration(cr.Latency),
+			IsAlive:    cr.IsAlive,
+			StatusCode: cr.StatusCode,
+			Error:      cr.Err,
+		},
+	}
+	if policy.Type == models.CheckTCP || policy.Type == models.CheckGRPC {
+		report.Target, _ = targetAddr(policy, job.InstanceAddr)
+	}
+	if !persist {
+		return report, nil
+	}
+
+	job.record(cr)
+	if job.setState(cr.IsAlive) {
+		job.transit(cr)
+	}
+	job.updateFlapping(cr.CheckTime)
+	report.Health = job.status(cr)
+
+	// flush with the pending status, so an older one never overwrites it
+	writer.push(key, report.Health)
+	writer.flush()
+	report.Persisted = true
+	return report, nil
+}
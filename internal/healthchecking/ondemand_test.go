@@ -0,0 +1,57 @@
+package healthchecking
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_CheckNow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	kapi := newMemKeysAPI()
+	statuses := new(countingStore)
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob)
+	sched = newScheduler(1, nil, nil)
+	checkerPool, _ = newCheckerPool(1, 1, defaultChekerFactory)
+	writer = newStatusWriter(statuses, time.Hour, 100)
+	defer func() { store, sched, checkerPool, writer = nil, nil, nil, nil }()
+
+	ins := &models.ServerInstance{ServerInstance: cmodels.ServerInstance{
+		Idx: "1", ClusterID: "1", Addr: srv.URL, NeedCheckHealth: true, HealthCheckURL: srv.URL + "/health"}}
+	data, _ := etcdutils.Encode(ins)
+	kapi.Set(context.Background(), "/clusters/1/1", string(data), nil)
+
+	// not checked, probe only
+	report, err := CheckNow("1", "1", false)
+	if err != nil || !report.Probe.IsAlive || report.Probe.StatusCode != http.StatusOK || report.Persisted {
+		t.Fatalf("want alive not persisted, got: %+v %v", report, err)
+	}
+	if _, err := CheckNow("1", "1", true); err != errNotHealthChecked {
+		t.Errorf("want errNotHealthChecked, got: %v", err)
+	}
+
+	// checked with rise = 3, persist takes effect at once
+	job := newInstanceJob("/clusters/1/1", ins, nil)
+	job.setPolicy(&models.HealthCheckPolicy{Rise: 3}, nil)
+	job.setState(false)
+	addJob("/clusters/1/1", job)
+	if report, err = CheckNow("1", "1", true); err != nil || !report.Persisted {
+		t.Fatalf("want persisted, got: %+v %v", report, err)
+	}
+	if isAlive, _ := job.state(); !isAlive {
+		t.Errorf("want the job alive at once")
+	}
+	status := new(models.HealthStatus)
+	if err := etcdutils.Decode(statuses.data[models.HealthStatusKeyOf("/clusters/1/1")], status); err != nil || !status.IsAlive {
+		t.Errorf("want alive status written, got: %+v %v", status, err)
+	}
+}
@@ -0,0 +1,146 @@
+package healthchecking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// defaultPassiveInterval is the interval to judge the passive reports
+const defaultPassiveInterval = 5 * time.Second
+
+// updatePassive set the traffic reported by gateways, the instance is
+// failing while the reported requests reach PassiveMinReqs and the error
+// rate reaches PassiveErrorRate. returns true while failing changed.
+func (job *HealthJob) updatePassive(stats *models.PassiveStats) bool {
+	policy, _ := job.getPolicy()
+	if stats != nil {
+		requests := stats.Successes + stats.Errors
+		if requests > 0 {
+			stats.ErrorRate = float64(stats.Errors) / float64(requests)
+		}
+		stats.Failing = policy.PassiveErrorRate > 0 && requests >= policy.PassiveMinReqs &&
+			stats.ErrorRate >= policy.PassiveErrorRate
+	}
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	failing := job.passive != nil && job.passive.Failing
+	job.passive = stats
+	return failing != (stats != nil && stats.Failing)
+}
+
+// observePassive reports whether the failing report of time t has not been
+// counted as a failure yet, so every window reported counts once
+func (job *HealthJob) observePassive(t time.Time) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if !t.After(job.passiveSeen) {
+		return false
+	}
+	job.passiveSeen = t
+	return true
+}
+
+// passiveFailing reports whether gateways report the instance failing
+func (job *HealthJob) passiveFailing() bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.passive != nil && job.passive.Failing
+}
+
+// detectPassive judge the passive reports every interval, it never returns
+func detectPassive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		checkPassive(loadPassiveStats(), now)
+	}
+}
+
+// checkPassive judge the jobs checked by this replica with the traffic
+// reported by gateways. every failing window reported counts as a failed
+// check, so the instance is marked dead after policy.Fall of them like by
+// the probes, and it becomes alive by the probes only after the gateways
+// stop reporting it failing. the status is written while failing or the
+// state changes.
+func checkPassive(stats map[string]*models.PassiveStats, now time.Time) {
+	jobs := make(map[string]*HealthJob)
+	taskQMutex.RLock()
+	for key, job := range taskQ {
+		if sched != nil && sched.has(key) {
+			jobs[key] = job
+		}
+	}
+	taskQMutex.RUnlock()
+
+	for key, job := range jobs {
+		failingChanged := job.updatePassive(stats[key])
+
+		cr, changed := job.latestResult(now), false
+		if s := stats[key]; s != nil && s.Failing && job.observePassive(s.Time) {
+			passive := checkResult{
+				Key:       key,
+				CheckTime: now,
+				Err:       fmt.Sprintf("gateways reported error rate %.2f of %d requests", s.ErrorRate, s.Successes+s.Errors),
+				Signal:    models.SignalPassive,
+			}
+			if changed = job.observe(false); changed {
+				logger.Logger.Infof("instance[%s] is marked dead by passive reports: %s", key, passive.Err)
+				job.transit(passive)
+				cr = passive
+			}
+		}
+		if !failingChanged && !changed {
+			continue
+		}
+		job.updateFlapping(now)
+		pushStatus(writer, job, cr)
+	}
+}
+
+// loadPassiveStats load the reports of all gateways, summed by instance key
+func loadPassiveStats() map[string]*models.PassiveStats {
+	stats := make(map[string]*models.PassiveStats)
+	resp, err := store.Kapi.Get(context.Background(), models.PassiveKey, &client.GetOptions{Recursive: true})
+	if err != nil {
+		if !isErrorCode(err, client.ErrorCodeKeyNotFound) {
+			logger.Logger.Errorf("loadPassiveStats got err: %v", err)
+		}
+		return stats
+	}
+
+	// /passive/{gateway}
+	for _, gatewayNode := range resp.Node.Nodes {
+		report := new(models.PassiveReport)
+		if err := etcdutils.Decode(gatewayNode.Value, report); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		for _, ins := range report.Instances {
+			if ins == nil {
+				continue
+			}
+			key := configs.ClustersKey + ins.ClusterID + "/" + ins.InstanceID
+			s, ok := stats[key]
+			if !ok {
+				s = new(models.PassiveStats)
+				stats[key] = s
+			}
+			s.Successes += ins.Successes
+			s.Errors += ins.Errors
+			s.Gateways++
+			if report.Time.After(s.Time) {
+				s.Time = report.Time
+			}
+		}
+	}
+	return stats
+}
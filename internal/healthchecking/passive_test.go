@@ -0,0 +1,94 @@
+package healthchecking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_LoadPassiveStats(t *testing.T) {
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	defer func() { store = nil }()
+
+	for gateway, errs := range map[string]int{"gw1": 3, "gw2": 5} {
+		v, _ := etcdutils.Encode(&models.PassiveReport{Instances: []*models.InstanceReport{
+			{ClusterID: "1", InstanceID: "a", Successes: 10, Errors: errs},
+			{ClusterID: "1", InstanceID: "b", Successes: 10},
+		}})
+		kapi.Set(context.Background(), models.PassiveReportKey(gateway), v, nil)
+	}
+
+	stats := loadPassiveStats()
+	s := stats["/clusters/1/a"]
+	if len(stats) != 2 || s == nil || s.Successes != 20 || s.Errors != 8 || s.Gateways != 2 {
+		t.Errorf("want reports summed by instance, got: %+v", stats)
+	}
+}
+
+func Test_CheckPassive(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	ring = nil
+	sched = newScheduler(1, nil, nil)
+	store := new(countingStore)
+	writer = newStatusWriter(store, time.Hour, 100)
+	defer func() { sched, writer = nil, nil }()
+
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/a")
+	job.setPolicy(&models.HealthCheckPolicy{PassiveErrorRate: 0.5, PassiveMinReqs: 10, Fall: 2}, nil)
+	job.setState(true)
+	addJob(job.InstanceKey, job)
+	written := func() *models.HealthStatus {
+		writer.flush()
+		status := new(models.HealthStatus)
+		etcdutils.Decode(store.data[models.HealthStatusKeyOf(job.InstanceKey)], status)
+		return status
+	}
+
+	// too few requests to judge
+	window := time.Now()
+	checkPassive(map[string]*models.PassiveStats{job.InstanceKey: {Successes: 1, Errors: 5, Time: window}}, time.Now())
+	if isAlive, _ := job.state(); !isAlive {
+		t.Fatalf("want alive with too few requests")
+	}
+
+	// every failing window counts once against policy.Fall
+	failing := func(window time.Time) {
+		checkPassive(map[string]*models.PassiveStats{job.InstanceKey: {Successes: 5, Errors: 15, Time: window}}, time.Now())
+	}
+	failing(window)
+	failing(window)
+	if isAlive, _ := job.state(); !isAlive {
+		t.Fatalf("want alive after a failing window with fall 2")
+	}
+	if status := written(); status.Passive == nil || !status.Passive.Failing {
+		t.Fatalf("want the failing reports written, got: %+v", status)
+	}
+	failing(window.Add(10 * time.Second))
+	status := written()
+	if status.IsAlive || status.Passive == nil || !status.Passive.Failing {
+		t.Fatalf("want marked dead by passive reports, got: %+v", status)
+	}
+	if last := status.Transitions[len(status.Transitions)-1]; last.Signal != models.SignalPassive {
+		t.Errorf("want the transition made by passive signal, got: %+v", last)
+	}
+
+	// probes can't bring it back while gateways report it failing
+	handleResult(checkResult{Key: job.InstanceKey, IsAlive: true, CheckTime: time.Now()}, writer)
+	if isAlive, _ := job.state(); isAlive {
+		t.Fatalf("want alive probes held while failing")
+	}
+
+	checkPassive(map[string]*models.PassiveStats{}, time.Now())
+	handleResult(checkResult{Key: job.InstanceKey, IsAlive: true, CheckTime: time.Now()}, writer)
+	status = written()
+	if !status.IsAlive || status.Passive != nil {
+		t.Fatalf("want alive by probes after the reports expired, got: %+v", status)
+	}
+	if last := status.Transitions[len(status.Transitions)-1]; last.Signal != models.SignalActive {
+		t.Errorf("want the transition made by active signal, got: %+v", last)
+	}
+}
@@ -0,0 +1,68 @@
+package healthchecking
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// targetAddr returns the host:port of tcp and grpc checks, policy.Target
+// first, or the host of the instance addr with the default port of its scheme
+func targetAddr(policy *models.HealthCheckPolicy, instanceAddr string) (string, error) {
+	if policy.Target != "" {
+		return policy.Target, nil
+	}
+
+	u, err := url.Parse(instanceAddr)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host in addr: %s", instanceAddr)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+// probeTCP succeeds while the connection is established
+func probeTCP(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeGRPC calls grpc.health.v1.Health/Check, succeeds while the
+// service is SERVING. an empty service means the whole server.
+func probeGRPC(addr, service string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx,
+		&grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc service %q is %s", service, resp.Status)
+	}
+	return nil
+}
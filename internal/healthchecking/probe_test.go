@@ -0,0 +1,101 @@
+package healthchecking
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_TargetAddr(t *testing.T) {
+	cases := []struct {
+		target string
+		addr   string
+		want   string
+	}{
+		{addr: "http://10.0.0.1:8080", want: "10.0.0.1:8080"},
+		{addr: "http://10.0.0.1", want: "10.0.0.1:80"},
+		{addr: "https://svc.internal", want: "svc.internal:443"},
+		{target: "10.0.0.2:6379", addr: "http://10.0.0.1:8080", want: "10.0.0.2:6379"},
+	}
+
+	for _, c := range cases {
+		got, err := targetAddr(&models.HealthCheckPolicy{Target: c.target}, c.addr)
+		if err != nil || got != c.want {
+			t.Errorf("targetAddr(%s, %s) want %s, got: %s, err: %v", c.target, c.addr, c.want, got, err)
+		}
+	}
+}
+
+func Test_CheckerProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	checker, _ := defaultChekerFactory()
+	job := newHealthJob("", "/clusters/1/1")
+	job.InstanceAddr = "http://" + addr
+	job.setPolicy(&models.HealthCheckPolicy{Type: models.CheckTCP, Timeout: models.Duration(time.Second)}, nil)
+
+	if cr := checker.probe(job); !cr.IsAlive {
+		t.Errorf("want alive, got err: %s", cr.Err)
+	}
+
+	ln.Close()
+	if cr := checker.probe(job); cr.IsAlive {
+		t.Errorf("want dead after listener closed, got alive")
+	}
+}
+
+func Test_CheckerProbeGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("up", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthSrv.SetServingStatus("down", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(srv, healthSrv)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	checker, _ := defaultChekerFactory()
+	cases := []struct {
+		service string
+		want    bool
+	}{
+		{service: "", want: true}, // the whole server
+		{service: "up", want: true},
+		{service: "down", want: false},
+		{service: "unknown", want: false},
+	}
+
+	for _, c := range cases {
+		job := newHealthJob("", "/clusters/1/1")
+		job.setPolicy(&models.HealthCheckPolicy{
+			Type:        models.CheckGRPC,
+			Target:      ln.Addr().String(),
+			GRPCService: c.service,
+			Timeout:     models.Duration(time.Second),
+		}, nil)
+		if cr := checker.probe(job); cr.IsAlive != c.want {
+			t.Errorf("service %q: want %v, got: %v (err: %s)", c.service, c.want, cr.IsAlive, cr.Err)
+		}
+	}
+}
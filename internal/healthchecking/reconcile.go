@@ -0,0 +1,198 @@
+package healthchecking
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// lastReconcile is the report of the latest reconciliation
+var lastReconcile atomic.Value
+
+// ReconcileReport is the drift between taskQ and the stored instances
+// fixed by a reconciliation
+type ReconcileReport struct {
+	Time     time.Time `json:"time"`
+	Index    uint64    `json:"index"`    // etcd index the instances were loaded at
+	Added    []string  `json:"added"`    // instances missing in taskQ
+	Removed  []string  `json:"removed"`  // jobs of deleted instances or not checked any more
+	Updated  []string  `json:"updated"`  // jobs with stale target, policy or holds
+	Mirrored []string  `json:"mirrored"` // instances whose IsAlive missed the effective state
+}
+
+// Drifted reports whether any job has been fixed
+func (r *ReconcileReport) Drifted() bool {
+	return len(r.Added)+len(r.Removed)+len(r.Updated)+len(r.Mirrored) > 0
+}
+
+// storedClusters is the cluster options and instances loaded at index
+type storedClusters struct {
+	index     uint64
+	options   map[string]*models.ClusterOption  // by clusterID
+	instances map[string]*models.ServerInstance // instances need check health, by key
+	unchecked map[string]*models.ServerInstance // instances not health checked with a health override, by key
+}
+
+// loadClusters load the whole clusters tree in one read, so it's
+// consistent at the index of the response
+func loadClusters(kapi client.KeysAPI) (*storedClusters, error) {
+	resp, err := kapi.Get(context.Background(), configs.ClustersKey, &client.GetOptions{Recursive: true})
+	if err != nil {
+		return nil, err
+	} else if !resp.Node.Dir {
+		return nil, errors.New("configs.ClustersKey is not a dir")
+	}
+
+	stored := &storedClusters{
+		index:     resp.Index,
+		options:   make(map[string]*models.ClusterOption),
+		instances: make(map[string]*models.ServerInstance),
+		unchecked: make(map[string]*models.ServerInstance),
+	}
+	for _, clusterNode := range resp.Node.Nodes {
+		if !clusterNode.Dir {
+			continue
+		}
+		clusterID := clusterIDOf(clusterNode.Key)
+		for _, node := range clusterNode.Nodes {
+			switch {
+			case isOptionKey(node.Key):
+				clsOpt := new(models.ClusterOption)
+				if err := etcdutils.Decode(node.Value, clsOpt); err != nil {
+					logger.Logger.Errorf("etcdutils.Decode(v, clsOpt) failed: err %v, v=[%s]", err, node.Value)
+					continue
+				}
+				stored.options[clusterID] = clsOpt
+			case isInstanceKey(node.Key):
+				srvInsCfg := new(models.ServerInstance)
+				if err := etcdutils.Decode(node.Value, srvInsCfg); err != nil {
+					logger.Logger.Error(err)
+					continue
+				}
+				// if need check health of server instance
+				if srvInsCfg.NeedCheckHealth {
+					stored.instances[node.Key] = srvInsCfg
+				} else if srvInsCfg.HealthOverride != nil {
+					stored.unchecked[node.Key] = srvInsCfg
+				}
+			}
+		}
+	}
+	return stored, nil
+}
+
+// reconcile make taskQ and the cluster options match the stored ones,
+// and push the state of the jobs and the instances not health checked
+// again while their health override expired.
+// it's called by the watcher only, so no event is applied meanwhile.
+func reconcile(kapi client.KeysAPI) (*ReconcileReport, error) {
+	stored, err := loadClusters(kapi)
+	if err != nil {
+		return nil, err
+	}
+	report := &ReconcileReport{Time: time.Now(), Index: stored.index}
+
+	clusterOptsMutex.Lock()
+	clusterOpts = stored.options
+	clusterOptsMutex.Unlock()
+
+	taskQMutex.RLock()
+	jobs := make(map[string]*HealthJob, len(taskQ))
+	for key, job := range taskQ {
+		jobs[key] = job
+	}
+	taskQMutex.RUnlock()
+
+	for key, ins := range stored.instances {
+		job, ok := jobs[key]
+		clsPolicy, clsPause := clusterPolicy(clusterIDOf(key)), clusterPause(clusterIDOf(key))
+		switch {
+		case !ok:
+			report.Added = append(report.Added, key)
+			newJob := newInstanceJob(key, ins, nil)
+			addJob(key, newJob)
+			pushIfEffectiveChanged(key, newJob)
+		case job.TargetURL != ins.HealthCheckURL || job.InstanceAddr != ins.Addr:
+			report.Updated = append(report.Updated, key)
+			newJob := newInstanceJob(key, ins, job)
+			addJob(key, newJob)
+			pushIfEffectiveChanged(key, newJob)
+		case !job.matches(ins, clsPolicy, clsPause):
+			report.Updated = append(report.Updated, key)
+			job.setWeight(ins.Weight)
+			job.setPolicy(ins.HealthCheck, clsPolicy)
+			job.setHolds(ins.HealthOverride, ins.HealthPause, clsPause)
+			touchJob(key)
+			pushIfEffectiveChanged(key, job)
+		default:
+			// the health override may have expired since the latest status,
+			// which is not pushed by probes while the job is paused
+			pushIfEffectiveChanged(key, job)
+			if mirrorDrifted(key, job, ins) {
+				report.Mirrored = append(report.Mirrored, key)
+			}
+		}
+	}
+	for key, ins := range stored.unchecked {
+		if mirrorUnchecked(key, ins) {
+			report.Mirrored = append(report.Mirrored, key)
+		}
+	}
+	for key := range jobs {
+		// the synthetic jobs are synced with the synthetic probes
+		if isSyntheticKey(key) {
+			continue
+		}
+		if _, ok := stored.instances[key]; !ok {
+			report.Removed = append(report.Removed, key)
+			removeJob(key)
+		}
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Updated)
+	sort.Strings(report.Mirrored)
+	return report, nil
+}
+
+// matches reports whether the weight, policy and holds of the job are
+// the ones of the instance and its cluster
+func (job *HealthJob) matches(ins *models.ServerInstance, clsPolicy *models.HealthCheckPolicy, clsPause *models.HealthPause) bool {
+	policy := mergePolicy(job.InstanceKey, ins.HealthCheck, clsPolicy)
+
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.weight == ins.Weight &&
+		reflect.DeepEqual(job.policy, policy) &&
+		reflect.DeepEqual(job.override, ins.HealthOverride) &&
+		reflect.DeepEqual(job.insPause, ins.HealthPause) &&
+		reflect.DeepEqual(job.clusterPause, clsPause)
+}
+
+// reportDrift keep the report and log the jobs fixed by the reconciliation
+func reportDrift(report *ReconcileReport) {
+	lastReconcile.Store(report)
+	if !report.Drifted() {
+		return
+	}
+	logger.Logger.Warnf("healthchecking reconciled taskQ at index %d, added: [%s], removed: [%s], updated: [%s], mirrored: [%s]",
+		report.Index, strings.Join(report.Added, ","), strings.Join(report.Removed, ","), strings.Join(report.Updated, ","),
+		strings.Join(report.Mirrored, ","))
+}
+
+// LastReconcile returns the report of the latest reconciliation, maybe nil
+func LastReconcile() *ReconcileReport {
+	report, _ := lastReconcile.Load().(*ReconcileReport)
+	return report
+}
@@ -0,0 +1,320 @@
+package healthchecking
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// entry is a job in the scheduler
+type entry struct {
+	job     *HealthJob
+	next    time.Time // next run time
+	index   int       // index in the heap, -1 while the job is running
+	running bool      // the job is being checked
+	fails   int       // consecutive failed checks, to back off
+}
+
+// entryHeap is a min heap of entries ordered by next run time
+type entryHeap []*entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
+
+// probeFunc checks a job and returns the result
+type probeFunc func(job *HealthJob) checkResult
+
+// scheduler runs each job at its deadline on a bounded pool of workers.
+// a job is never run concurrently with itself, the next deadline is set
+// after the check is done, so a slow instance never piles up checks.
+// deadlines are jittered by the policy, and backed off while the instance
+// keeps failing after it's dead.
+type scheduler struct {
+	mutex   sync.Mutex
+	heap    entryHeap
+	entries map[string]*entry // instance key to entry
+	wakeup  chan struct{}     // signal the loop that the heap top changed
+
+	work    chan *HealthJob
+	probe   probeFunc
+	results chan<- checkResult
+	workers int
+	quit    chan struct{}
+	rand    func() float64 // returns a number in [0, 1), to jitter deadlines
+}
+
+func newScheduler(workers int, probe probeFunc, results chan<- checkResult) *scheduler {
+	return &scheduler{
+		heap:    make(entryHeap, 0),
+		entries: make(map[string]*entry),
+		wakeup:  make(chan struct{}, 1),
+		work:    make(chan *HealthJob),
+		probe:   probe,
+		results: results,
+		workers: workers,
+		quit:    make(chan struct{}),
+		rand:    rand.Float64,
+	}
+}
+
+// poolProbe returns a probeFunc which takes a checker from the pool
+// and puts it back after the check
+func poolProbe(pool *chanCheckerPool) probeFunc {
+	return func(job *HealthJob) checkResult {
+		checker, err := pool.Get()
+		if err != nil {
+			logger.Logger.Errorf("poolProbe() pool.Get() got err: %v", err)
+			return checkResult{Key: job.InstanceKey, CheckTime: time.Now(), Err: err.Error(), job: job}
+		}
+		defer pool.Put(checker)
+		return checker.probe(job)
+	}
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// add schedule the job to run within the jitter of its interval, so jobs
+// added together are spread. the job of the same key is replaced,
+// if the replaced one is running its result would be dropped by handleResult.
+// the job itself in flight is kept, done schedules its next run
+func (s *scheduler) add(job *HealthJob) {
+	policy, _ := job.getPolicy()
+	delay := time.Duration(s.rand() * policy.Jitter * float64(policy.Interval))
+
+	s.mutex.Lock()
+	if e, ok := s.entries[job.InstanceKey]; ok && e.job == job && e.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.removeLocked(job.InstanceKey)
+	e := &entry{job: job, next: time.Now().Add(delay), index: -1}
+	s.entries[job.InstanceKey] = e
+	heap.Push(&s.heap, e)
+	s.mutex.Unlock()
+	s.notify()
+}
+
+// remove cancel the job, a running check is not interrupted
+// but the job would not be scheduled again
+func (s *scheduler) remove(key string) {
+	s.mutex.Lock()
+	s.removeLocked(key)
+	s.mutex.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) removeLocked(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	if e.index >= 0 {
+		heap.Remove(&s.heap, e.index)
+	}
+}
+
+// touch bring the next run of the job forward while its interval
+// has been shortened by a policy change, or it has been resumed
+func (s *scheduler) touch(key string) {
+	s.mutex.Lock()
+	e, ok := s.entries[key]
+	if !ok || e.index < 0 {
+		s.mutex.Unlock()
+		return
+	}
+	policy, _ := e.job.getPolicy()
+	if next := time.Now().Add(s.nextDelay(policy, e.fails)); next.Before(e.next) {
+		e.next = next
+		heap.Fix(&s.heap, e.index)
+	}
+	s.mutex.Unlock()
+	s.notify()
+}
+
+// nextDelay returns the jittered interval after a check, it backs off
+// once the failures reach policy.Fall, and resets on success
+func (s *scheduler) nextDelay(policy *models.HealthCheckPolicy, fails int) time.Duration {
+	delay := float64(policy.Backoff(fails - policy.Fall))
+	return time.Duration(delay * (1 + policy.Jitter*(s.rand()-0.5)))
+}
+
+// done schedule the next run of the job, unless it has been removed or
+// replaced, or added again after the check began so it's in the heap already
+func (s *scheduler) done(job *HealthJob, isAlive bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[job.InstanceKey]
+	if !ok || e.job != job || !e.running || e.index >= 0 {
+		return
+	}
+	if isAlive {
+		e.fails = 0
+	} else {
+		e.fails++
+	}
+	policy, _ := job.getPolicy()
+	e.running = false
+	e.next = time.Now().Add(s.nextDelay(policy, e.fails))
+	heap.Push(&s.heap, e)
+	if e.index == 0 {
+		s.notify()
+	}
+}
+
+// entryInfo is a snapshot of an entry
+type entryInfo struct {
+	next    time.Time // next run time, meaningless while running
+	running bool
+	started time.Time // start time of the running or latest check
+}
+
+// snapshot returns the entries in the scheduler by key
+func (s *scheduler) snapshot() map[string]entryInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	infos := make(map[string]entryInfo, len(s.entries))
+	for key, e := range s.entries {
+		infos[key] = entryInfo{next: e.next, running: e.running, started: e.job.lastCheckTime}
+	}
+	return infos
+}
+
+// pending returns count of results waiting to be handled
+func (s *scheduler) pending() (n, capacity int) {
+	return len(s.results), cap(s.results)
+}
+
+// has reports whether the job of key is in the scheduler
+func (s *scheduler) has(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.entries[key]
+	return ok
+}
+
+// len returns count of jobs in the scheduler
+func (s *scheduler) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+// stop the scheduler and its workers, running checks are not interrupted
+func (s *scheduler) stop() {
+	close(s.quit)
+}
+
+// run starts the workers and dispatches the due jobs to them,
+// it returns after the scheduler is stopped
+func (s *scheduler) run() {
+	for i := 0; i < s.workers; i++ {
+		go s.worker()
+	}
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mutex.Lock()
+		if len(s.heap) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-s.wakeup:
+			case <-s.quit:
+				return
+			}
+			continue
+		}
+
+		e := s.heap[0]
+		if wait := time.Until(e.next); wait > 0 {
+			s.mutex.Unlock()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-s.wakeup:
+			case <-s.quit:
+				return
+			}
+			continue
+		}
+
+		heap.Pop(&s.heap)
+		// a paused job waits for the pause to expire, or to be resumed by touch
+		if until := e.job.pausedUntil(time.Now()); !until.IsZero() {
+			e.next = until
+			heap.Push(&s.heap, e)
+			s.mutex.Unlock()
+			continue
+		}
+		e.running = true
+		e.job.lastCheckTime = time.Now()
+		job := e.job
+		s.mutex.Unlock()
+
+		// blocks while all workers are busy
+		select {
+		case s.work <- job:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *scheduler) worker() {
+	for {
+		var job *HealthJob
+		select {
+		case job = <-s.work:
+		case <-s.quit:
+			return
+		}
+
+		cr := s.probe(job)
+		cr.job = job
+		select {
+		case s.results <- cr:
+		case <-s.quit:
+			return
+		}
+		s.done(job, cr.IsAlive)
+	}
+}
@@ -0,0 +1,194 @@
+package healthchecking
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func newIntervalJob(key string, interval time.Duration) *HealthJob {
+	job := newHealthJob("http://127.0.0.1/health", key)
+	job.setPolicy(&models.HealthCheckPolicy{
+		Interval: models.Duration(interval),
+		Timeout:  models.Duration(interval),
+	}, nil)
+	return job
+}
+
+func Test_Scheduler(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		running = make(map[string]bool)
+		counts  = make(map[string]int)
+	)
+	probe := func(job *HealthJob) checkResult {
+		mutex.Lock()
+		if running[job.InstanceKey] {
+			t.Errorf("job[%s] is running concurrently", job.InstanceKey)
+		}
+		running[job.InstanceKey] = true
+		counts[job.InstanceKey]++
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		running[job.InstanceKey] = false
+		mutex.Unlock()
+		return checkResult{Key: job.InstanceKey, IsAlive: true}
+	}
+
+	results := make(chan checkResult, 100)
+	s := newScheduler(2, probe, results)
+	s.rand = func() float64 { return 0 } // no delay on first schedule
+	go s.run()
+	defer s.stop()
+	go func() {
+		for range results {
+		}
+	}()
+
+	s.add(newIntervalJob("/clusters/1/1", 10*time.Millisecond))
+	s.add(newIntervalJob("/clusters/1/2", 10*time.Millisecond))
+	s.add(newIntervalJob("/clusters/1/3", time.Hour))
+	time.Sleep(100 * time.Millisecond)
+
+	s.remove("/clusters/1/2")
+	mutex.Lock()
+	removed := counts["/clusters/1/2"]
+	mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if n := counts["/clusters/1/1"]; n < 5 {
+		t.Errorf("want /clusters/1/1 checked at its interval, got: %d checks", n)
+	}
+	if n := counts["/clusters/1/2"]; n > removed+1 {
+		t.Errorf("want /clusters/1/2 cancelled, got: %d checks after removed", n-removed)
+	}
+	if n := counts["/clusters/1/3"]; n != 1 {
+		t.Errorf("want /clusters/1/3 checked once, got: %d", n)
+	}
+	if n := s.len(); n != 2 {
+		t.Errorf("want 2 jobs, got: %d", n)
+	}
+}
+
+func Test_SchedulerNextDelay(t *testing.T) {
+	policy := (&models.HealthCheckPolicy{
+		Fall:          2,
+		Interval:      models.Duration(time.Second),
+		BackoffFactor: 2,
+		MaxInterval:   models.Duration(5 * time.Second),
+		Jitter:        0.2,
+	}).Merge(nil)
+
+	s := newScheduler(1, nil, nil)
+	s.rand = func() float64 { return 0.5 } // no jitter
+	steps := []struct {
+		fails int
+		want  time.Duration
+	}{
+		{fails: 0, want: time.Second},
+		{fails: 2, want: time.Second}, // just dead
+		{fails: 3, want: 2 * time.Second},
+		{fails: 4, want: 4 * time.Second},
+		{fails: 5, want: 5 * time.Second}, // capped
+		{fails: 20, want: 5 * time.Second},
+	}
+	for _, step := range steps {
+		if got := s.nextDelay(policy, step.fails); got != step.want {
+			t.Errorf("fails %d: want %s, got: %s", step.fails, step.want, got)
+		}
+	}
+
+	s.rand = func() float64 { return 0 }
+	if got := s.nextDelay(policy, 0); got != 900*time.Millisecond {
+		t.Errorf("want jitter down to 900ms, got: %s", got)
+	}
+}
+
+func Test_SchedulerAddWhileRunning(t *testing.T) {
+	s := newScheduler(1, nil, nil)
+	job := newIntervalJob("/clusters/1/1", time.Second)
+	// start pops the job like the dispatch loop does
+	start := func() {
+		e := heap.Pop(&s.heap).(*entry)
+		e.running = true
+	}
+
+	// the job is added again while running, like regained within the handover grace
+	s.add(job)
+	start()
+	s.add(job)
+	s.done(job, true)
+	if len(s.heap) != 1 || len(s.entries) != 1 {
+		t.Fatalf("want the job scheduled once, got %d in heap, %d entries", len(s.heap), len(s.entries))
+	}
+	s.remove(job.InstanceKey)
+	if len(s.heap) != 0 || len(s.entries) != 0 {
+		t.Fatalf("want the job removed, got %d in heap, %d entries", len(s.heap), len(s.entries))
+	}
+
+	// removed and added again while running
+	s.add(job)
+	start()
+	s.remove(job.InstanceKey)
+	s.add(job)
+	s.done(job, true)
+	if len(s.heap) != 1 || len(s.entries) != 1 {
+		t.Fatalf("want the job scheduled once, got %d in heap, %d entries", len(s.heap), len(s.entries))
+	}
+	s.remove(job.InstanceKey)
+	if len(s.heap) != 0 {
+		t.Errorf("want the job removed, got %d in heap", len(s.heap))
+	}
+}
+
+// Benchmark_SchedulerAddRemove schedules and cancels 10k jobs
+func Benchmark_SchedulerAddRemove(b *testing.B) {
+	const jobs = 10000
+
+	s := newScheduler(1, nil, nil)
+	keys := make([]string, jobs)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/clusters/bench/%d", i)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			s.add(newIntervalJob(key, time.Second))
+		}
+		for _, key := range keys {
+			s.remove(key)
+		}
+	}
+}
+
+// Benchmark_SchedulerDispatch runs 10k jobs with a no-op probe,
+// each op is a round of 10k checks
+func Benchmark_SchedulerDispatch(b *testing.B) {
+	const jobs = 10000
+
+	results := make(chan checkResult, 100)
+	probe := func(job *HealthJob) checkResult {
+		return checkResult{Key: job.InstanceKey, IsAlive: true}
+	}
+	s := newScheduler(defaultWorkers, probe, results)
+	for i := 0; i < jobs; i++ {
+		s.add(newIntervalJob(fmt.Sprintf("/clusters/bench/%d", i), time.Millisecond))
+	}
+
+	b.ResetTimer()
+	go s.run()
+	defer s.stop()
+	for n := 0; n < b.N*jobs; n++ {
+		<-results
+	}
+}
@@ -0,0 +1,120 @@
+package healthchecking
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// defaultShadowInterval is the interval to load the global shadow mode
+const defaultShadowInterval = 5 * time.Second
+
+var (
+	globalShadow atomic.Value  // *models.ShadowMode, nil while not set
+	shadowWriter *statusWriter // writer of the status reached in shadow mode
+)
+
+// clusterShadow returns the shadow mode of the cluster, maybe nil
+func clusterShadow(clusterID string) *models.ShadowMode {
+	clusterOptsMutex.RLock()
+	defer clusterOptsMutex.RUnlock()
+
+	if clsOpt, ok := clusterOpts[clusterID]; ok {
+		return clsOpt.HealthShadow
+	}
+	return nil
+}
+
+// shadowOf returns the shadow mode the instance is in at now, the one
+// of its cluster wins over the global one. nil while not in shadow mode,
+// synthetic probes are never in shadow mode
+func shadowOf(key string, now time.Time) *models.ShadowMode {
+	if isSyntheticKey(key) {
+		return nil
+	}
+	if mode := clusterShadow(clusterIDOf(key)); mode.Active(now) {
+		return mode
+	}
+	if mode, _ := globalShadow.Load().(*models.ShadowMode); mode.Active(now) {
+		return mode
+	}
+	return nil
+}
+
+// pushStatus queue the status of the job after the check. in shadow mode
+// it's queued to the shadow writer instead, so the stored status and
+// IsAlive are kept as they are. w could be nil
+func pushStatus(w *statusWriter, job *HealthJob, cr checkResult) {
+	if mode := shadowOf(job.InstanceKey, cr.CheckTime); mode != nil {
+		if shadowWriter != nil {
+			shadowWriter.push(job.InstanceKey, job.shadowStatus(cr, mode))
+		}
+		return
+	}
+	if w != nil {
+		w.push(job.InstanceKey, job.status(cr))
+	}
+}
+
+// detectShadow load the global shadow mode every interval, it never returns
+func detectShadow(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		mode, err := loadGlobalShadow()
+		if err != nil {
+			logger.Logger.Errorf("healthchecking load the global shadow mode got err: %v", err)
+			continue
+		}
+		setGlobalShadow(mode)
+	}
+}
+
+// loadGlobalShadow load the global shadow mode, nil while not set
+func loadGlobalShadow() (*models.ShadowMode, error) {
+	v, err := store.Get(models.ShadowKey)
+	if err != nil {
+		if isErrorCode(err, client.ErrorCodeKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	mode := new(models.ShadowMode)
+	if err := etcdutils.Decode(v, mode); err != nil {
+		return nil, err
+	}
+	return mode, nil
+}
+
+// setGlobalShadow keep the global shadow mode, the jobs whose effective
+// state differs from the status queued are pushed again while it changed
+func setGlobalShadow(mode *models.ShadowMode) {
+	old, _ := globalShadow.Load().(*models.ShadowMode)
+	globalShadow.Store(mode)
+	if sameShadow(old, mode) {
+		return
+	}
+
+	logger.Logger.Infof("healthchecking global shadow mode changed to %+v", mode)
+	taskQMutex.RLock()
+	jobs := make(map[string]*HealthJob, len(taskQ))
+	for key, job := range taskQ {
+		jobs[key] = job
+	}
+	taskQMutex.RUnlock()
+	for key, job := range jobs {
+		pushIfEffectiveChanged(key, job)
+	}
+}
+
+func sameShadow(a, b *models.ShadowMode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Since.Equal(b.Since) && a.Until.Equal(b.Until)
+}
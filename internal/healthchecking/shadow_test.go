@@ -0,0 +1,69 @@
+package healthchecking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_HandleResultShadow(t *testing.T) {
+	taskQ = make(map[string]*HealthJob)
+	clusterOpts = make(map[string]*models.ClusterOption)
+	job := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/1")
+	job.restore(&models.HealthStatus{IsAlive: true})
+	taskQ[job.InstanceKey] = job
+
+	store := new(countingStore)
+	writer := newStatusWriter(store, time.Hour, 100)
+	defer func(w *statusWriter) { shadowWriter = w }(shadowWriter)
+	shadowWriter = newStatusWriter(store, time.Hour, 100)
+	shadowWriter.keyOf = models.ShadowStatusKeyOf
+	flush := func() {
+		writer.flush()
+		shadowWriter.flush()
+	}
+	written := func(key string) *models.HealthStatus {
+		flush()
+		v, ok := store.data[key]
+		if !ok {
+			return nil
+		}
+		status := new(models.HealthStatus)
+		etcdutils.Decode(v, status)
+		return status
+	}
+	dead := func() { handleResult(checkResult{Key: job.InstanceKey, Err: "down", CheckTime: time.Now()}, writer) }
+
+	now := time.Now()
+	clusterOpts["1"] = &models.ClusterOption{HealthShadow: &models.ShadowMode{Since: now, Until: now.Add(time.Hour)}}
+	dead()
+	if status := written(models.HealthStatusKeyOf(job.InstanceKey)); status != nil {
+		t.Fatalf("want no status written in shadow mode, got: %+v", status)
+	}
+	if status := written(models.ShadowStatusKeyOf(job.InstanceKey)); status == nil || status.IsAlive {
+		t.Fatalf("want dead shadow status, got: %+v", status)
+	}
+	sets := store.sets
+	dead()
+	if flush(); store.sets != sets {
+		t.Errorf("want nothing written without change")
+	}
+
+	// a new global shadow mode writes the shadow status again
+	clusterOpts["1"] = &models.ClusterOption{}
+	globalShadow.Store(&models.ShadowMode{Since: now.Add(time.Second), Until: now.Add(time.Hour)})
+	defer globalShadow.Store((*models.ShadowMode)(nil))
+	dead()
+	if flush(); store.sets != sets+1 {
+		t.Errorf("want the shadow status written in the new shadow mode, got %d sets", store.sets-sets)
+	}
+
+	// leaving the shadow mode writes the checked state
+	globalShadow.Store((*models.ShadowMode)(nil))
+	dead()
+	if status := written(models.HealthStatusKeyOf(job.InstanceKey)); status == nil || status.IsAlive {
+		t.Errorf("want dead status after leaving the shadow mode, got: %+v", status)
+	}
+}
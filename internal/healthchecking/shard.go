@@ -0,0 +1,102 @@
+package healthchecking
+
+import (
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/membership"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+var (
+	selfReplica   *models.Manager        // this replica
+	replicas      *membership.Membership // live replicas
+	ring          *membership.Ring       // ring of live replicas, protected by taskQMutex
+	handoverGrace time.Duration          // duration a replica keeps checking the jobs it lost
+)
+
+// owns reports whether this replica checks the job of key, all jobs are
+// owned before the replicas are known. caller must hold taskQMutex.
+func owns(key string) bool {
+	return ring == nil || ring.Owner(key) == selfReplica.ID
+}
+
+// onReplicasChange rebalance the jobs by the new ring. the gained jobs are
+// scheduled at once with the status written by the previous owner, the lost
+// jobs are checked for handoverGrace more, so the new owner has checked
+// them before, and no instance goes unchecked during the handover.
+func onReplicasChange(ids []string) {
+	logger.Logger.Infof("healthchecking replicas changed: %v", ids)
+
+	// this replica is alive even if its registration failed
+	hasSelf := false
+	for _, id := range ids {
+		hasSelf = hasSelf || id == selfReplica.ID
+	}
+	if !hasSelf {
+		ids = append(ids, selfReplica.ID)
+	}
+	newRing := membership.NewRing(ids)
+
+	var (
+		gained []*HealthJob
+		lost   []string
+	)
+	taskQMutex.Lock()
+	for key, job := range taskQ {
+		owned, owning := owns(key), newRing.Owner(key) == selfReplica.ID
+		switch {
+		case !owned && owning:
+			gained = append(gained, job)
+		case owned && !owning:
+			lost = append(lost, key)
+		}
+	}
+	ring = newRing
+	taskQMutex.Unlock()
+	logger.Logger.Infof("healthchecking rebalanced, gained: %d, lost: %d", len(gained), len(lost))
+
+	for _, job := range gained {
+		if status := loadHealthStatus(job.InstanceKey); status != nil {
+			job.restore(status)
+		}
+	}
+	taskQMutex.Lock()
+	for _, job := range gained {
+		// the job may have been replaced or lost again while loading
+		if taskQ[job.InstanceKey] == job && owns(job.InstanceKey) {
+			sched.add(job)
+		}
+	}
+	taskQMutex.Unlock()
+
+	if len(lost) > 0 {
+		time.AfterFunc(handoverGrace, func() { releaseJobs(lost) })
+	}
+}
+
+// releaseJobs stop checking the jobs unless they have been owned again
+func releaseJobs(keys []string) {
+	taskQMutex.Lock()
+	defer taskQMutex.Unlock()
+	for _, key := range keys {
+		if !owns(key) {
+			sched.remove(key)
+		}
+	}
+}
+
+// Replicas returns the live replicas
+func Replicas() []*models.Manager {
+	return replicas.Members()
+}
+
+// Self returns this replica
+func Self() *models.Manager {
+	return selfReplica
+}
+
+// CheckingJobs returns count of jobs this replica is checking
+func CheckingJobs() int {
+	return sched.len()
+}
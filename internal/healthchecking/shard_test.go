@@ -0,0 +1,75 @@
+package healthchecking
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/membership"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_OnReplicasChange(t *testing.T) {
+	const jobs = 100
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob, jobs)
+	sched = newScheduler(1, nil, nil)
+	selfReplica = &models.Manager{ID: "a"}
+	handoverGrace = 50 * time.Millisecond
+	defer func() { store, sched, ring = nil, nil, nil }()
+
+	for i := 0; i < jobs; i++ {
+		key := fmt.Sprintf("/clusters/1/%d", i)
+		addJob(key, newHealthJob("http://127.0.0.1/health", key))
+	}
+	if n := sched.len(); n != jobs {
+		t.Fatalf("want all jobs owned before replicas known, got: %d", n)
+	}
+
+	shared := membership.NewRing([]string{"a", "b"})
+	owned := 0
+	for key := range taskQ {
+		if shared.Owner(key) == "a" {
+			owned++
+		}
+	}
+
+	// b joins, the lost jobs are checked until the grace ends
+	onReplicasChange([]string{"a", "b"})
+	if n := sched.len(); n != jobs {
+		t.Errorf("want lost jobs checked during handover, got: %d", n)
+	}
+	time.Sleep(2 * handoverGrace)
+	if n := sched.len(); n != owned {
+		t.Errorf("want %d jobs owned, got: %d", owned, n)
+	}
+
+	// a is missing from the registrations, but it's still alive
+	onReplicasChange([]string{"b"})
+	time.Sleep(2 * handoverGrace)
+	if n := sched.len(); n != owned {
+		t.Errorf("want %d jobs owned, got: %d", owned, n)
+	}
+
+	// b leaves, the gained jobs are checked at once with the status of b
+	var gainedKey string
+	for key := range taskQ {
+		if shared.Owner(key) == "b" {
+			gainedKey = key
+			break
+		}
+	}
+	data, _ := etcdutils.Encode(&models.HealthStatus{IsAlive: true})
+	kapi.Set(context.Background(), models.HealthStatusKeyOf(gainedKey), string(data), nil)
+
+	onReplicasChange([]string{"a"})
+	if n := sched.len(); n != jobs {
+		t.Errorf("want all jobs owned, got: %d", n)
+	}
+	if isAlive, known := taskQ[gainedKey].state(); !known || !isAlive {
+		t.Errorf("want status of the previous owner loaded, got: %v %v", isAlive, known)
+	}
+}
@@ -0,0 +1,142 @@
+package healthchecking
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.etcd.io/etcd/client"
+)
+
+// memKeysAPI is a local stand-in of the etcd keys api, dirs are implied
+// by the keys, events are kept for watchers
+type memKeysAPI struct {
+	client.KeysAPI
+
+	mutex    sync.Mutex
+	values   map[string]string
+	modified map[string]uint64 // index each value was set at
+	index    uint64
+	events   []*client.Response
+	cleared  uint64        // events till cleared are lost
+	changed  chan struct{} // closed while an event is appended
+}
+
+func newMemKeysAPI() *memKeysAPI {
+	return &memKeysAPI{values: make(map[string]string), modified: make(map[string]uint64), changed: make(chan struct{})}
+}
+
+func (k *memKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if v, ok := k.values[key]; ok {
+		node := &client.Node{Key: key, Value: v, ModifiedIndex: k.modified[key]}
+		return &client.Response{Action: "get", Index: k.index, Node: node}, nil
+	}
+
+	dir := &client.Node{Key: strings.TrimSuffix(key, "/"), Dir: true}
+	for k, v := range k.values {
+		if strings.HasPrefix(k, dir.Key+"/") {
+			addNode(dir, strings.Split(strings.TrimPrefix(k, dir.Key+"/"), "/"), v)
+		}
+	}
+	if len(dir.Nodes) == 0 {
+		return nil, client.Error{Code: client.ErrorCodeKeyNotFound, Message: "Key not found", Cause: key}
+	}
+	return &client.Response{Action: "get", Index: k.index, Node: dir}, nil
+}
+
+// addNode add the node of path under dir
+func addNode(dir *client.Node, path []string, v string) {
+	key := dir.Key + "/" + path[0]
+	if len(path) == 1 {
+		dir.Nodes = append(dir.Nodes, &client.Node{Key: key, Value: v})
+		sort.Slice(dir.Nodes, func(i, j int) bool { return dir.Nodes[i].Key < dir.Nodes[j].Key })
+		return
+	}
+	for _, node := range dir.Nodes {
+		if node.Key == key {
+			addNode(node, path[1:], v)
+			return
+		}
+	}
+	node := &client.Node{Key: key, Dir: true}
+	dir.Nodes = append(dir.Nodes, node)
+	addNode(node, path[1:], v)
+}
+
+func (k *memKeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if opts != nil && opts.PrevIndex != 0 && opts.PrevIndex != k.modified[key] {
+		return nil, client.Error{Code: client.ErrorCodeTestFailed, Message: "Compare failed", Cause: key}
+	}
+	k.values[key] = value
+	resp := k.appendEvent("set", key, value)
+	k.modified[key] = resp.Index
+	return resp, nil
+}
+
+func (k *memKeysAPI) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for stored := range k.values {
+		if stored == key || (opts != nil && opts.Recursive && strings.HasPrefix(stored, key+"/")) {
+			delete(k.values, stored)
+		}
+	}
+	return k.appendEvent("delete", key, ""), nil
+}
+
+func (k *memKeysAPI) appendEvent(action, key, value string) *client.Response {
+	k.index++
+	resp := &client.Response{Action: action, Index: k.index, Node: &client.Node{Key: key, Value: value, ModifiedIndex: k.index}}
+	k.events = append(k.events, resp)
+	close(k.changed)
+	k.changed = make(chan struct{})
+	return resp
+}
+
+// clear drop the events so far from the history, as etcd does
+// while more events happened than its history holds
+func (k *memKeysAPI) clear() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.cleared = k.index
+}
+
+func (k *memKeysAPI) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	return &memWatcher{kapi: k, prefix: key, next: opts.AfterIndex + 1}
+}
+
+type memWatcher struct {
+	kapi   *memKeysAPI
+	prefix string
+	next   uint64
+}
+
+func (w *memWatcher) Next(ctx context.Context) (*client.Response, error) {
+	for {
+		w.kapi.mutex.Lock()
+		if w.next <= w.kapi.cleared {
+			w.kapi.mutex.Unlock()
+			return nil, client.Error{Code: client.ErrorCodeEventIndexCleared, Message: "The event in requested index is outdated and cleared"}
+		}
+		for _, resp := range w.kapi.events {
+			if resp.Index >= w.next && strings.HasPrefix(resp.Node.Key, w.prefix) {
+				w.next = resp.Index + 1
+				w.kapi.mutex.Unlock()
+				return resp, nil
+			}
+		}
+		changed := w.kapi.changed
+		w.kapi.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
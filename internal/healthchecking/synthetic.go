@@ -0,0 +1,171 @@
+package healthchecking
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// defaultSyntheticInterval is the interval to sync the synthetic jobs with
+// the probes, and the apis and routings they target
+const defaultSyntheticInterval = 10 * time.Second
+
+// "/synthetics/{probeID}"
+func isSyntheticKey(key string) bool {
+	return strings.HasPrefix(key, models.SyntheticsKey)
+}
+
+// statusKeyOf returns the key the health status of the instance or the
+// synthetic probe is written to
+func statusKeyOf(key string) string {
+	if isSyntheticKey(key) {
+		return models.SyntheticStatusKeyOf(key)
+	}
+	return models.HealthStatusKeyOf(key)
+}
+
+// syntheticRequest is the request of a synthetic probe
+type syntheticRequest struct {
+	targetURL string
+	policy    *models.HealthCheckPolicy
+}
+
+// detectSynthetics sync the synthetic jobs every interval, it never returns
+func detectSynthetics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := syncSynthetics(store.Kapi); err != nil {
+			logger.Logger.Errorf("healthchecking sync synthetic probes got err: %v", err)
+		}
+	}
+}
+
+// syncSynthetics make the synthetic jobs in taskQ match the stored probes.
+// the probes whose api or routing is not found are not checked.
+func syncSynthetics(kapi client.KeysAPI) error {
+	requests, err := loadSyntheticRequests(kapi)
+	if err != nil {
+		return err
+	}
+
+	taskQMutex.RLock()
+	jobs := make(map[string]*HealthJob)
+	for key, job := range taskQ {
+		if isSyntheticKey(key) {
+			jobs[key] = job
+		}
+	}
+	taskQMutex.RUnlock()
+
+	for key, req := range requests {
+		job, ok := jobs[key]
+		switch {
+		case !ok || job.TargetURL != req.targetURL:
+			newJob := newHealthJob(req.targetURL, key)
+			newJob.setPolicy(req.policy, nil)
+			if ok {
+				newJob.inherit(job)
+			} else if status := loadHealthStatus(key); status != nil {
+				newJob.restore(status)
+			}
+			addJob(key, newJob)
+		case !reflect.DeepEqual(job.policyOf(), mergePolicy(key, req.policy, nil)):
+			job.setPolicy(req.policy, nil)
+			touchJob(key)
+		}
+	}
+	for key := range jobs {
+		if _, ok := requests[key]; !ok {
+			removeJob(key)
+		}
+	}
+	return nil
+}
+
+// policyOf returns the merged policy of the job
+func (job *HealthJob) policyOf() *models.HealthCheckPolicy {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.policy
+}
+
+// loadSyntheticRequests load the probes with the apis and routings they
+// target, the requests are by probe key
+func loadSyntheticRequests(kapi client.KeysAPI) (map[string]*syntheticRequest, error) {
+	probes, err := loadNodes(kapi, models.SyntheticsKey)
+	if err != nil || len(probes) == 0 {
+		return make(map[string]*syntheticRequest), err
+	}
+	apis, err := loadNodes(kapi, configs.APIsKey)
+	if err != nil {
+		return nil, err
+	}
+	routings, err := loadNodes(kapi, configs.RoutingsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make(map[string]*syntheticRequest, len(probes))
+	for key, v := range probes {
+		probe := new(models.SyntheticProbe)
+		if err := etcdutils.Decode(v, probe); err != nil {
+			logger.Logger.Errorf("etcdutils.Decode(v, probe) failed: err %v, v=[%s]", err, v)
+			continue
+		}
+
+		var (
+			api     *cmodels.API
+			routing *cmodels.Routing
+		)
+		if v, ok := apis[configs.APIsKey+probe.APIID]; ok && probe.APIID != "" {
+			api = new(cmodels.API)
+			if err := etcdutils.Decode(v, api); err != nil {
+				api = nil
+			}
+		}
+		if v, ok := routings[configs.RoutingsKey+probe.RoutingID]; ok && probe.RoutingID != "" {
+			routing = new(cmodels.Routing)
+			if err := etcdutils.Decode(v, routing); err != nil {
+				routing = nil
+			}
+		}
+
+		targetURL, policy, err := probe.Request(api, routing)
+		if err != nil {
+			logger.Logger.Errorf("synthetic probe %s is not checked: %v", key, err)
+			continue
+		}
+		// the probes are http checks only
+		policy.Type = models.CheckHTTP
+		requests[key] = &syntheticRequest{targetURL: targetURL, policy: policy}
+	}
+	return requests, nil
+}
+
+// loadNodes load the values under dir by key, empty while dir is not found
+func loadNodes(kapi client.KeysAPI, dir string) (map[string]string, error) {
+	values := make(map[string]string)
+	resp, err := kapi.Get(context.Background(), dir, nil)
+	if err != nil {
+		if isErrorCode(err, client.ErrorCodeKeyNotFound) {
+			return values, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			values[node.Key] = node.Value
+		}
+	}
+	return values, nil
+}
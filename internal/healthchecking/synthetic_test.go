@@ -0,0 +1,69 @@
+package healthchecking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_SyncSynthetics(t *testing.T) {
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob)
+	ring, sched = nil, nil
+	defer func() { store = nil }()
+
+	set := func(key string, v interface{}) {
+		data, _ := etcdutils.Encode(v)
+		kapi.Set(context.Background(), key, string(data), nil)
+	}
+	set(configs.APIsKey+"a1", &cmodels.API{Idx: "a1", Path: "/users/:id", Method: "POST"})
+	set(configs.RoutingsKey+"r1", &cmodels.Routing{Idx: "r1", Prefix: "/srv/"})
+	set(models.SyntheticKey("p1"), &models.SyntheticProbe{Idx: "p1", GatewayURL: "http://gw:8080/", APIID: "a1", Path: "/users/1"})
+	set(models.SyntheticKey("p2"), &models.SyntheticProbe{Idx: "p2", GatewayURL: "http://gw:8080", RoutingID: "r1", Path: "/ping"})
+	set(models.SyntheticKey("p3"), &models.SyntheticProbe{Idx: "p3", GatewayURL: "http://gw:8080", APIID: "missing"})
+	set(models.SyntheticStatusKey("p1"), &models.HealthStatus{IsAlive: false})
+	instance := newHealthJob("http://127.0.0.1:9091/health", "/clusters/1/a")
+	taskQ[instance.InstanceKey] = instance
+
+	if err := syncSynthetics(kapi); err != nil {
+		t.Fatal(err)
+	}
+	p1, p2 := taskQ[models.SyntheticKey("p1")], taskQ[models.SyntheticKey("p2")]
+	if p1 == nil || p1.TargetURL != "http://gw:8080/users/1" || p2 == nil || p2.TargetURL != "http://gw:8080/srv/ping" {
+		t.Fatalf("want the jobs requesting through the gateway, got: %+v, %+v", p1, p2)
+	}
+	if policy := p1.policyOf(); policy.Type != models.CheckHTTP || policy.Method != "POST" {
+		t.Errorf("want an http check with the method of the api, got: %+v", policy)
+	}
+	if isAlive, _ := p1.state(); isAlive {
+		t.Errorf("want the stored status restored")
+	}
+	if _, ok := taskQ[models.SyntheticKey("p3")]; ok {
+		t.Errorf("want the probe of a missing api not checked")
+	}
+
+	// the changed probe inherits the state, the deleted routing stops its probe
+	set(models.SyntheticKey("p1"), &models.SyntheticProbe{Idx: "p1", GatewayURL: "http://gw:8080", APIID: "a1", Path: "/users/2"})
+	kapi.Delete(context.Background(), configs.RoutingsKey+"r1", nil)
+	if err := syncSynthetics(kapi); err != nil {
+		t.Fatal(err)
+	}
+	p1 = taskQ[models.SyntheticKey("p1")]
+	if p1 == nil || p1.TargetURL != "http://gw:8080/users/2" {
+		t.Fatalf("want the job of p1 replaced, got: %+v", p1)
+	}
+	if isAlive, _ := p1.state(); isAlive {
+		t.Errorf("want the state inherited by the replaced job")
+	}
+	if _, ok := taskQ[models.SyntheticKey("p2")]; ok {
+		t.Errorf("want the job of p2 removed")
+	}
+	if taskQ[instance.InstanceKey] != instance {
+		t.Errorf("want the instance jobs untouched")
+	}
+}
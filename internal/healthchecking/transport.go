@@ -0,0 +1,89 @@
+package healthchecking
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// maxTransports caps the cached transports, all of them are dropped
+// while it's exceeded
+const maxTransports = 256
+
+var (
+	transports      = make(map[string]*http.Transport) // by the hash of tls options
+	transportsMutex sync.Mutex
+)
+
+// transportOf returns the transport of the tls options, it's cached by
+// the options, so the connections are reused among the checks
+func transportOf(opts *models.HealthCheckTLS) (*http.Transport, error) {
+	hash := sha256.New()
+	for _, field := range []string{opts.CA, opts.Cert, opts.Key, opts.ServerName,
+		strconv.FormatBool(opts.InsecureSkipVerify)} {
+		hash.Write([]byte(field))
+		hash.Write([]byte{0})
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	if transport, ok := transports[key]; ok {
+		return transport, nil
+	}
+
+	cfg, err := tlsConfigOf(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(transports) >= maxTransports {
+		for _, transport := range transports {
+			transport.CloseIdleConnections()
+		}
+		transports = make(map[string]*http.Transport)
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     cfg,
+	}
+	transports[key] = transport
+	return transport, nil
+}
+
+// tlsConfigOf build the tls config of the options
+func tlsConfigOf(opts *models.HealthCheckTLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CA != "" {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM([]byte(opts.CA)) {
+			return nil, errors.New("tls ca has no PEM certificate")
+		}
+	}
+	if opts.Cert != "" {
+		cert, err := tls.X509KeyPair([]byte(opts.Cert), []byte(opts.Key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,59 @@
+package healthchecking
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
+)
+
+func Test_CheckerProbeTLSAuth(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "probe" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	if err := secrets.SetKey([]byte("test-key")); err != nil {
+		t.Fatal(err)
+	}
+	auth := &models.HealthCheckAuth{Type: models.AuthBasic, Username: "probe", Password: "secret"}
+	sealed := &models.HealthCheckPolicy{Auth: auth}
+	if err := secrets.SealPolicy(sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		policy *models.HealthCheckPolicy
+		alive  bool
+	}{
+		{name: "system roots", policy: &models.HealthCheckPolicy{Auth: auth, TLS: &models.HealthCheckTLS{}}},
+		{name: "no auth", policy: &models.HealthCheckPolicy{TLS: &models.HealthCheckTLS{CA: ca}}},
+		{name: "insecure", policy: &models.HealthCheckPolicy{Auth: auth, TLS: &models.HealthCheckTLS{InsecureSkipVerify: true}}, alive: true},
+		{name: "wrong server name", policy: &models.HealthCheckPolicy{Auth: auth, TLS: &models.HealthCheckTLS{CA: ca, ServerName: "other"}}},
+		// the sealed password of the instance is opened, the ca is of the cluster
+		{name: "sealed auth", policy: sealed, alive: true},
+	}
+
+	checker, _ := defaultChekerFactory()
+	clusterPolicy := &models.HealthCheckPolicy{TLS: &models.HealthCheckTLS{CA: ca}, Timeout: models.Duration(time.Second)}
+	for _, c := range cases {
+		job := newHealthJob(srv.URL, "/clusters/1/1")
+		job.setPolicy(c.policy, clusterPolicy)
+		if cr := checker.probe(job); cr.IsAlive != c.alive {
+			t.Errorf("%s: want alive %v, got err: %s", c.name, c.alive, cr.Err)
+		}
+	}
+	if !secrets.IsSealed(sealed.Auth.Password) {
+		t.Error("the policy of the instance is opened in place")
+	}
+}
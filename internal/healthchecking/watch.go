@@ -0,0 +1,123 @@
+package healthchecking
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"go.etcd.io/etcd/client"
+)
+
+// watchRetryDelay is the delay to watch or resync again after an error
+const watchRetryDelay = time.Second
+
+// clusterWatch watches the clusters tree from the index last seen, so no
+// event is missed while watching again. taskQ is resynced while the index
+// has been cleared from the etcd event history, and reconciled with the
+// stored instances every interval to fix any drift left.
+type clusterWatch struct {
+	kapi     client.KeysAPI
+	index    uint64        // index of the latest event applied or resync
+	interval time.Duration // interval to reconcile taskQ
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// newClusterWatch create a watch of the clusters after index
+func newClusterWatch(kapi client.KeysAPI, index uint64, interval time.Duration) *clusterWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &clusterWatch{
+		kapi:     kapi,
+		index:    index,
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Index returns the index of the latest event applied or resync
+func (w *clusterWatch) Index() uint64 {
+	return atomic.LoadUint64(&w.index)
+}
+
+// run apply the events with callback until stop
+func (w *clusterWatch) run(callback func(op etcdutils.OpCode, key, v string)) {
+	next := time.Now().Add(w.interval)
+	etcdWatcher := w.watcher()
+	for {
+		ctx, cancel := context.WithDeadline(w.ctx, next)
+		resp, err := etcdWatcher.Next(ctx)
+		cancel()
+
+		switch {
+		case w.ctx.Err() != nil:
+			return
+		case err == nil:
+			atomic.StoreUint64(&w.index, resp.Node.ModifiedIndex)
+			dispatch(resp, callback)
+			continue
+		case ctx.Err() == context.DeadlineExceeded:
+			next = time.Now().Add(w.interval)
+			w.resync()
+		case isErrorCode(err, client.ErrorCodeEventIndexCleared):
+			logger.Logger.Warnf("healthchecking watch index %d cleared, resync taskQ: %v", w.Index(), err)
+			w.resync()
+		default:
+			logger.Logger.Errorf("healthchecking watch after index %d got err: %v", w.Index(), err)
+			w.sleep(watchRetryDelay)
+		}
+		// watch again from the index last seen
+		etcdWatcher = w.watcher()
+	}
+}
+
+// resync reconcile taskQ with the stored instances, and watch from the
+// index they're loaded at
+func (w *clusterWatch) resync() {
+	report, err := reconcile(w.kapi)
+	if err != nil {
+		logger.Logger.Errorf("healthchecking reconcile taskQ got err: %v", err)
+		w.sleep(watchRetryDelay)
+		return
+	}
+	reportDrift(report)
+	atomic.StoreUint64(&w.index, report.Index)
+}
+
+func (w *clusterWatch) watcher() client.Watcher {
+	return w.kapi.Watcher(configs.ClustersKey, &client.WatcherOptions{AfterIndex: w.Index(), Recursive: true})
+}
+
+func (w *clusterWatch) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-w.ctx.Done():
+	}
+}
+
+// stop the watch
+func (w *clusterWatch) stop() {
+	w.cancel()
+}
+
+// dispatch convert the event to the op of callback
+func dispatch(resp *client.Response, callback func(op etcdutils.OpCode, key, v string)) {
+	switch resp.Action {
+	case "set", "update", "create", "compareAndSwap":
+		callback(etcdutils.SetOp, resp.Node.Key, resp.Node.Value)
+	case "delete", "compareAndDelete":
+		callback(etcdutils.DeleteOp, resp.Node.Key, resp.Node.Value)
+	case "expire":
+		callback(etcdutils.ExpireOp, resp.Node.Key, "")
+	default:
+		logger.Logger.Warnf("healthchecking watch got unknown action: %s", resp.Action)
+	}
+}
+
+func isErrorCode(err error, code int) bool {
+	cErr, ok := err.(client.Error)
+	return ok && cErr.Code == code
+}
@@ -0,0 +1,81 @@
+package healthchecking
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func setInstance(kapi *memKeysAPI, key, url string, policy *models.HealthCheckPolicy) {
+	ins := &models.ServerInstance{
+		ServerInstance: cmodels.ServerInstance{Addr: "127.0.0.1:8080", HealthCheckURL: url, NeedCheckHealth: true},
+		HealthCheck:    policy,
+	}
+	v, _ := etcdutils.Encode(ins)
+	kapi.Set(context.Background(), key, string(v), nil)
+}
+
+func jobOf(key string) *HealthJob {
+	taskQMutex.RLock()
+	defer taskQMutex.RUnlock()
+	return taskQ[key]
+}
+
+func Test_ClusterWatchResync(t *testing.T) {
+	kapi := newMemKeysAPI()
+	store = &etcdutils.EtcdStore{Kapi: kapi}
+	taskQ = make(map[string]*HealthJob)
+	clusterOpts = make(map[string]*models.ClusterOption)
+	defer func() { store = nil }()
+
+	setInstance(kapi, "/clusters/1/a", "http://127.0.0.1:8080/health", nil)
+	setInstance(kapi, "/clusters/1/b", "http://127.0.0.1:8080/health", nil)
+	index, err := initTaskQ(kapi)
+	if err != nil || index != 2 || len(taskQ) != 2 {
+		t.Fatalf("want 2 jobs loaded at index 2, got: %d %d %v", len(taskQ), index, err)
+	}
+
+	w := newClusterWatch(kapi, index, time.Hour)
+	go w.run(clusterWatchCallback)
+	defer w.stop()
+
+	setInstance(kapi, "/clusters/1/c", "http://127.0.0.1:8080/health", nil)
+	time.Sleep(20 * time.Millisecond)
+	if jobOf("/clusters/1/c") == nil || w.Index() != 3 {
+		t.Fatalf("want the watched instance added at index 3, got index: %d", w.Index())
+	}
+
+	// the events are cleared before watched, taskQ is resynced
+	w.stop()
+	w = newClusterWatch(kapi, w.Index(), time.Hour)
+	setInstance(kapi, "/clusters/1/b", "http://127.0.0.1:8080/health", &models.HealthCheckPolicy{Rise: 5})
+	kapi.Delete(context.Background(), "/clusters/1/a", nil)
+	setInstance(kapi, "/clusters/1/d", "http://127.0.0.1:8080/health", nil)
+	kapi.clear()
+	go w.run(clusterWatchCallback)
+	defer w.stop()
+	time.Sleep(20 * time.Millisecond)
+
+	report := LastReconcile()
+	if report == nil || report.Index != 6 || w.Index() != 6 {
+		t.Fatalf("want resynced at index 6, got: %+v", report)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "/clusters/1/d" ||
+		len(report.Removed) != 1 || report.Removed[0] != "/clusters/1/a" ||
+		len(report.Updated) != 1 || report.Updated[0] != "/clusters/1/b" {
+		t.Errorf("want d added, a removed and b updated, got: %+v", report)
+	}
+	if policy, _ := jobOf("/clusters/1/b").getPolicy(); policy.Rise != 5 {
+		t.Errorf("want policy of b updated, got rise: %d", policy.Rise)
+	}
+
+	// nothing drifted since
+	report, _ = reconcile(kapi)
+	if report.Drifted() {
+		t.Errorf("want no drift, got: %+v", report)
+	}
+}
@@ -0,0 +1,119 @@
+package healthchecking
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+const (
+	defaultFlushInterval = 500 * time.Millisecond // flush pending status at least this often
+	defaultFlushSize     = 100                    // flush at once while pending status reach this size
+)
+
+// statusStore is the part of etcdutils.EtcdStore the writer needs
+type statusStore interface {
+	Set(k, v string, expire time.Duration) error
+}
+
+// statusWriter coalesces health status of instances and writes them
+// in batches, a newer status of the same instance replaces the pending one.
+// flushes are serialized, so a batch taken later is never overwritten by
+// an earlier one still being written.
+type statusWriter struct {
+	store     statusStore
+	interval  time.Duration
+	flushSize int
+	keyOf     func(instanceKey string) string // key the status is written to
+	// mirror write the status into the instance value too, nil for none
+	mirror func(instanceKey string, status *models.HealthStatus) error
+
+	mutex   sync.Mutex
+	pending map[string]*models.HealthStatus // instance key to status
+	full    chan struct{}                   // signal to flush before the interval
+
+	flushMutex sync.Mutex // held while a batch is taken and written
+}
+
+func newStatusWriter(store statusStore, interval time.Duration, flushSize int) *statusWriter {
+	return &statusWriter{
+		store:     store,
+		interval:  interval,
+		flushSize: flushSize,
+		keyOf:     statusKeyOf,
+		pending:   make(map[string]*models.HealthStatus),
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// push queue the status of the instance to be written
+func (w *statusWriter) push(instanceKey string, status *models.HealthStatus) {
+	w.mutex.Lock()
+	w.pending[instanceKey] = status
+	n := len(w.pending)
+	w.mutex.Unlock()
+
+	if n >= w.flushSize {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flush the pending status periodically, it never returns
+func (w *statusWriter) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.full:
+		}
+		w.flush()
+	}
+}
+
+// flush write all the pending status, the failed ones are queued again
+// unless a newer status has been pushed. returns count of written status.
+// it's safe to call concurrently with run, it waits for the flush in progress
+func (w *statusWriter) flush() int {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+
+	w.mutex.Lock()
+	batch := w.pending
+	w.pending = make(map[string]*models.HealthStatus, len(batch))
+	w.mutex.Unlock()
+
+	written := 0
+	for instanceKey, status := range batch {
+		if err := w.write(instanceKey, status); err != nil {
+			logger.Logger.Errorf("statusWriter.flush() write(%s) got err: %v", instanceKey, err)
+			w.mutex.Lock()
+			if _, ok := w.pending[instanceKey]; !ok {
+				w.pending[instanceKey] = status
+			}
+			w.mutex.Unlock()
+			continue
+		}
+		written++
+	}
+	return written
+}
+
+// write the status of the instance, and mirror it into the instance value
+func (w *statusWriter) write(instanceKey string, status *models.HealthStatus) error {
+	data, _ := etcdutils.Encode(status)
+	if err := w.store.Set(w.keyOf(instanceKey), string(data), -1); err != nil {
+		return err
+	}
+	if w.mirror != nil {
+		return w.mirror(instanceKey, status)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package healthchecking
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// countingStore counts Set calls instead of writing etcd
+type countingStore struct {
+	mutex sync.Mutex
+	sets  int
+	data  map[string]string
+}
+
+func (s *countingStore) Set(k, v string, expire time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.data == nil {
+		s.data = make(map[string]string)
+	}
+	s.sets++
+	s.data[k] = v
+	return nil
+}
+
+func Test_StatusWriterCoalesce(t *testing.T) {
+	store := new(countingStore)
+	w := newStatusWriter(store, time.Hour, 100)
+
+	w.push("/clusters/1/1", &models.HealthStatus{IsAlive: true})
+	w.push("/clusters/1/1", &models.HealthStatus{IsAlive: false})
+	w.push("/clusters/1/2", &models.HealthStatus{IsAlive: true})
+
+	if n := w.flush(); n != 2 {
+		t.Errorf("want 2 written, got: %d", n)
+	}
+	status := new(models.HealthStatus)
+	v := store.data[models.HealthStatusKeyOf("/clusters/1/1")]
+	if err := etcdutils.Decode(v, status); err != nil || status.IsAlive {
+		t.Errorf("want the latest status written, got: %s", v)
+	}
+	if n := w.flush(); n != 0 {
+		t.Errorf("want nothing written, got: %d", n)
+	}
+}
+
+// blockingStore blocks the first Set until release is closed
+type blockingStore struct {
+	countingStore
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Set(k, v string, expire time.Duration) error {
+	s.once.Do(func() {
+		close(s.entered)
+		<-s.release
+	})
+	return s.countingStore.Set(k, v, expire)
+}
+
+func Test_StatusWriterFlushSerialized(t *testing.T) {
+	store := &blockingStore{entered: make(chan struct{}), release: make(chan struct{})}
+	w := newStatusWriter(store, time.Hour, 100)
+
+	// the on-demand check flushes while the periodic flush is writing
+	w.push("/clusters/1/1", &models.HealthStatus{IsAlive: false})
+	first := make(chan struct{})
+	go func() {
+		w.flush()
+		close(first)
+	}()
+	<-store.entered
+	w.push("/clusters/1/1", &models.HealthStatus{IsAlive: true})
+	second := make(chan struct{})
+	go func() {
+		w.flush()
+		close(second)
+	}()
+
+	select {
+	case <-second:
+		t.Fatalf("want the flush waiting for the one in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(store.release)
+	<-first
+	<-second
+
+	status := new(models.HealthStatus)
+	v := store.data[models.HealthStatusKeyOf("/clusters/1/1")]
+	if err := etcdutils.Decode(v, status); err != nil || !status.IsAlive {
+		t.Errorf("want the newer status written last, got: %s", v)
+	}
+}
+
+// Benchmark_HandleResult feeds probe results of 500 instances through
+// handleResult, every instance fails once in 50 probes and goes down for
+// 5 probes in 1000. writes/probe was 1 while every probe was written.
+func Benchmark_HandleResult(b *testing.B) {
+	const instances = 500
+
+	oldTaskQ := taskQ
+	defer func() { taskQ = oldTaskQ }()
+	taskQ = make(map[string]*HealthJob, instances)
+	keys := make([]string, instances)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/clusters/bench/%d", i)
+		job := newHealthJob("http://127.0.0.1/health", keys[i])
+		job.setPolicy(&models.HealthCheckPolicy{Rise: 2, Fall: 3}, nil)
+		taskQ[keys[i]] = job
+	}
+
+	store := new(countingStore)
+	writer := newStatusWriter(store, time.Hour, instances)
+	probes := 0
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, key := range keys {
+			round := probes / instances
+			isAlive := round%50 != 49 && round%1000 >= 5
+			handleResult(checkResult{Key: key, IsAlive: isAlive, CheckTime: time.Now()}, writer)
+			probes++
+		}
+		writer.flush()
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(store.sets)/float64(probes), "writes/probe")
+}
@@ -0,0 +1,51 @@
+package membership
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/client"
+)
+
+// etcdRegistry registers replicas in etcd v2 keys with TTL
+type etcdRegistry struct {
+	kapi client.KeysAPI
+}
+
+// NewEtcdRegistry create a Registry on etcd
+func NewEtcdRegistry(kapi client.KeysAPI) Registry {
+	return &etcdRegistry{kapi: kapi}
+}
+
+func (r *etcdRegistry) Register(key, value string, ttl time.Duration) error {
+	_, err := r.kapi.Set(context.Background(), key, value, &client.SetOptions{TTL: ttl})
+	return err
+}
+
+func (r *etcdRegistry) Deregister(key string) error {
+	_, err := r.kapi.Delete(context.Background(), key, nil)
+	if isKeyNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *etcdRegistry) List(dir string) ([]string, error) {
+	resp, err := r.kapi.Get(context.Background(), dir, nil)
+	if isKeyNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		values = append(values, node.Value)
+	}
+	return values, nil
+}
+
+func isKeyNotFound(err error) bool {
+	cErr, ok := err.(client.Error)
+	return ok && cErr.Code == client.ErrorCodeKeyNotFound
+}
@@ -0,0 +1,131 @@
+// Package membership registers manager replicas in the config store with
+// a TTL, so replicas discover each other and the dead ones expire.
+package membership
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// Registry is the store of replica registrations
+type Registry interface {
+	// Register set key to value with ttl
+	Register(key, value string, ttl time.Duration) error
+	// Deregister delete key
+	Deregister(key string) error
+	// List returns values of keys in dir
+	List(dir string) ([]string, error)
+}
+
+// Membership keeps this replica registered and tracks the live replicas,
+// the registration is refreshed every TTL/3, so a dead replica is
+// removed from others within TTL+TTL/3
+type Membership struct {
+	registry Registry
+	self     *models.Manager
+	ttl      time.Duration
+
+	mutex   sync.Mutex
+	members []*models.Manager // sorted by ID
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// New create a Membership of self
+func New(registry Registry, self *models.Manager, ttl time.Duration) *Membership {
+	return &Membership{
+		registry: registry,
+		self:     self,
+		ttl:      ttl,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Members returns the live replicas sorted by ID
+func (m *Membership) Members() []*models.Manager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.members
+}
+
+// Run register and list the replicas until Stop is called,
+// onChange is called with IDs of the live replicas while they change
+func (m *Membership) Run(onChange func(ids []string)) {
+	defer close(m.done)
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+
+	key := models.ReplicasKey + m.self.ID
+	data, _ := etcdutils.Encode(m.self)
+	for {
+		if err := m.registry.Register(key, string(data), m.ttl); err != nil {
+			logger.Logger.Errorf("Membership.Run() registry.Register(%s) got err: %v", key, err)
+		}
+		m.refresh(onChange)
+
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			if err := m.registry.Deregister(key); err != nil {
+				logger.Logger.Errorf("Membership.Run() registry.Deregister(%s) got err: %v", key, err)
+			}
+			return
+		}
+	}
+}
+
+// Stop deregister this replica, others take over at once
+func (m *Membership) Stop() {
+	close(m.quit)
+	<-m.done
+}
+
+func (m *Membership) refresh(onChange func([]string)) {
+	values, err := m.registry.List(models.ReplicasKey)
+	if err != nil {
+		logger.Logger.Errorf("Membership.refresh() registry.List() got err: %v", err)
+		return
+	}
+
+	members := make([]*models.Manager, 0, len(values))
+	for _, v := range values {
+		member := new(models.Manager)
+		if err := etcdutils.Decode(v, member); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+
+	m.mutex.Lock()
+	changed := !sameIDs(m.members, members)
+	m.members = members
+	m.mutex.Unlock()
+
+	if changed && onChange != nil {
+		ids := make([]string, len(members))
+		for i, member := range members {
+			ids[i] = member.ID
+		}
+		onChange(ids)
+	}
+}
+
+func sameIDs(a, b []*models.Manager) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package membership
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func TestMain(m *testing.M) {
+	if err := logger.Init(os.TempDir()); err != nil {
+		panic(err)
+	}
+	logger.Logger.Out = ioutil.Discard
+	os.Exit(m.Run())
+}
+
+// memRegistry is a local stand-in of etcd keys with ttl
+type memRegistry struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func (r *memRegistry) Register(key, value string, ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[key] = value
+	r.expires[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (r *memRegistry) Deregister(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.values, key)
+	return nil
+}
+
+func (r *memRegistry) List(dir string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var values []string
+	for key, v := range r.values {
+		if strings.HasPrefix(key, dir) && time.Now().Before(r.expires[key]) {
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+func Test_Membership(t *testing.T) {
+	const ttl = 90 * time.Millisecond
+	registry := &memRegistry{values: make(map[string]string), expires: make(map[string]time.Time)}
+
+	var (
+		mutex sync.Mutex
+		seen  []string
+	)
+	m1 := New(registry, &models.Manager{ID: "1"}, ttl)
+	go m1.Run(func(ids []string) {
+		mutex.Lock()
+		seen = ids
+		mutex.Unlock()
+	})
+	defer m1.Stop()
+
+	m2 := New(registry, &models.Manager{ID: "2"}, ttl)
+	go m2.Run(nil)
+	time.Sleep(ttl)
+
+	mutex.Lock()
+	if strings.Join(seen, ",") != "1,2" {
+		t.Errorf("want members 1,2, got: %v", seen)
+	}
+	mutex.Unlock()
+
+	m2.Stop()
+	time.Sleep(ttl / 2)
+	mutex.Lock()
+	if strings.Join(seen, ",") != "1" {
+		t.Errorf("want members 1 after 2 left, got: %v", seen)
+	}
+	mutex.Unlock()
+}
@@ -0,0 +1,60 @@
+package membership
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// defaultVirtualNodes is count of points of each member on the ring
+const defaultVirtualNodes = 128
+
+// Ring is a consistent hash ring of members, a key is owned by the first
+// member point clockwise from the hash of the key. adding or removing
+// a member only moves the keys of the member.
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing create a ring of members
+func NewRing(members []string) *Ring {
+	r := &Ring{
+		points: make([]uint32, 0, len(members)*defaultVirtualNodes),
+		owners: make(map[uint32]string, len(members)*defaultVirtualNodes),
+	}
+	for _, member := range members {
+		for i := 0; i < defaultVirtualNodes; i++ {
+			point := hash(member + "#" + strconv.Itoa(i))
+			// keep the smaller member while points collide, so every
+			// replica builds the same ring
+			if owner, ok := r.owners[point]; ok && owner < member {
+				continue
+			} else if !ok {
+				r.points = append(r.points, point)
+			}
+			r.owners[point] = member
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the member owns the key, "" while the ring is empty
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	idx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if idx == len(r.points) {
+		idx = 0
+	}
+	return r.owners[r.points[idx]]
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,37 @@
+package membership
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_RingBalance(t *testing.T) {
+	const keys = 10000
+	ring := NewRing([]string{"a", "b", "c"})
+
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[ring.Owner(fmt.Sprintf("/clusters/c%d/%d", i%50, i))]++
+	}
+	for member, n := range counts {
+		if n < keys/3*7/10 || n > keys/3*13/10 {
+			t.Errorf("want about %d keys of %s, got: %d", keys/3, member, n)
+		}
+	}
+}
+
+func Test_RingMinimalMove(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"})
+	after := NewRing([]string{"a", "b", "c", "d"})
+
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("/clusters/c/%d", i)
+		if o1, o2 := before.Owner(key), after.Owner(key); o1 != o2 && o2 != "d" {
+			t.Fatalf("key %s moved from %s to %s, want only moves to the new member", key, o1, o2)
+		}
+	}
+
+	if owner := NewRing(nil).Owner("/clusters/c/1"); owner != "" {
+		t.Errorf("want no owner of empty ring, got: %s", owner)
+	}
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// AlertingConfigKey is the key of the alerting config
+	AlertingConfigKey = "/alerting/config"
+	// AlertsKey is the key of the alerts pending or firing, it's written by
+	// the leader, so a new leader does not notify the firing alerts again
+	AlertsKey = "/alerting/alerts"
+)
+
+// AlertKind is the condition an alert rule watches
+type AlertKind string
+
+const (
+	// AlertInstanceDown an enabled instance is not alive
+	AlertInstanceDown AlertKind = "instance_down"
+	// AlertClusterDegraded a cluster is degraded or down against its min_healthy
+	AlertClusterDegraded AlertKind = "cluster_degraded"
+	// AlertFlapping an instance is flapping
+	AlertFlapping AlertKind = "flapping"
+	// AlertSyntheticFailing a synthetic probe is failing
+	AlertSyntheticFailing AlertKind = "synthetic_failing"
+)
+
+// Valid reports whether k is a known alert kind
+func (k AlertKind) Valid() bool {
+	switch k {
+	case AlertInstanceDown, AlertClusterDegraded, AlertFlapping, AlertSyntheticFailing:
+		return true
+	}
+	return false
+}
+
+// ChannelType is the type of a notification channel
+type ChannelType string
+
+const (
+	// ChannelWebhook posts the notification as JSON to an url
+	ChannelWebhook ChannelType = "webhook"
+	// ChannelSMTP mails the notification through a smtp server
+	ChannelSMTP ChannelType = "smtp"
+)
+
+// Valid reports whether t is a known channel type
+func (t ChannelType) Valid() bool {
+	return t == ChannelWebhook || t == ChannelSMTP
+}
+
+// AlertingConfig is the rules and the notification channels
+type AlertingConfig struct {
+	Rules    []*AlertRule    `json:"rules"`
+	Channels []*AlertChannel `json:"channels"`
+}
+
+// Channel returns the channel of name, nil if not found
+func (cfg *AlertingConfig) Channel(name string) *AlertChannel {
+	for _, ch := range cfg.Channels {
+		if ch.Name == name {
+			return ch
+		}
+	}
+	return nil
+}
+
+// HasKind returns whether any rule watches kind
+func (cfg *AlertingConfig) HasKind(kind AlertKind) bool {
+	for _, rule := range cfg.Rules {
+		if rule.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the channels of cfg, the rules are shared
+func (cfg *AlertingConfig) Clone() *AlertingConfig {
+	cloned := &AlertingConfig{Rules: cfg.Rules, Channels: make([]*AlertChannel, len(cfg.Channels))}
+	for idx, ch := range cfg.Channels {
+		cloned.Channels[idx] = ch.Clone()
+	}
+	return cloned
+}
+
+// MapSecrets replace every secret of the channels which is set by fn of
+// it in place
+func (cfg *AlertingConfig) MapSecrets(fn func(v string) (string, error)) error {
+	for _, ch := range cfg.Channels {
+		if err := ch.MapSecrets(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redacted returns a copy of cfg without the secrets of the channels
+func (cfg *AlertingConfig) Redacted() *AlertingConfig {
+	redacted := cfg.Clone()
+	redacted.MapSecrets(func(string) (string, error) { return "", nil })
+	return redacted
+}
+
+// KeepSecrets fill the empty secrets of the channels from the ones of
+// the same name in old
+func (cfg *AlertingConfig) KeepSecrets(old *AlertingConfig) {
+	if old == nil {
+		return
+	}
+	for _, ch := range cfg.Channels {
+		ch.KeepSecrets(old.Channel(ch.Name))
+	}
+}
+
+// AlertRule fires an alert for each target matching the condition longer
+// than For, the alert is notified once until it's resolved, or again every
+// RepeatInterval while it's set
+type AlertRule struct {
+	ID             string    `json:"id"`
+	Kind           AlertKind `json:"kind"`
+	ClusterID      string    `json:"cluster_id,omitempty"` // only the cluster, empty means all
+	ProbeID        string    `json:"probe_id,omitempty"`   // only the synthetic probe, empty means all
+	For            Duration  `json:"for,omitempty"`
+	RepeatInterval Duration  `json:"repeat_interval,omitempty"`
+	Channels       []string  `json:"channels"` // names of channels to notify
+}
+
+// AlertChannel is a notification channel, Webhook or SMTP is set by Type
+type AlertChannel struct {
+	Name    string          `json:"name"`
+	Type    ChannelType     `json:"type"`
+	Webhook *WebhookChannel `json:"webhook,omitempty"`
+	SMTP    *SMTPChannel    `json:"smtp,omitempty"`
+}
+
+// Clone returns a deep copy of ch
+func (ch *AlertChannel) Clone() *AlertChannel {
+	cloned := *ch
+	if ch.Webhook != nil {
+		webhook := *ch.Webhook
+		if ch.Webhook.Headers != nil {
+			webhook.Headers = make(map[string]string, len(ch.Webhook.Headers))
+			for name, value := range ch.Webhook.Headers {
+				webhook.Headers[name] = value
+			}
+		}
+		cloned.Webhook = &webhook
+	}
+	if ch.SMTP != nil {
+		smtp := *ch.SMTP
+		cloned.SMTP = &smtp
+	}
+	return &cloned
+}
+
+// MapSecrets replace every secret of ch which is set by fn of it in place,
+// the secrets are the smtp password and the values of the webhook headers
+func (ch *AlertChannel) MapSecrets(fn func(v string) (string, error)) error {
+	if ch.SMTP != nil && ch.SMTP.Password != "" {
+		v, err := fn(ch.SMTP.Password)
+		if err != nil {
+			return err
+		}
+		ch.SMTP.Password = v
+	}
+	if ch.Webhook == nil {
+		return nil
+	}
+	for name, value := range ch.Webhook.Headers {
+		if value == "" {
+			continue
+		}
+		v, err := fn(value)
+		if err != nil {
+			return err
+		}
+		ch.Webhook.Headers[name] = v
+	}
+	return nil
+}
+
+// KeepSecrets fill the empty secrets of ch from old, the smtp password
+// of the same username and the webhook headers of the same name. old
+// could be nil
+func (ch *AlertChannel) KeepSecrets(old *AlertChannel) {
+	if old == nil {
+		return
+	}
+	if ch.SMTP != nil && old.SMTP != nil && ch.SMTP.Password == "" && ch.SMTP.Username == old.SMTP.Username {
+		ch.SMTP.Password = old.SMTP.Password
+	}
+	if ch.Webhook == nil || old.Webhook == nil {
+		return
+	}
+	for name, value := range ch.Webhook.Headers {
+		if value != "" {
+			continue
+		}
+		for oldName, oldValue := range old.Webhook.Headers {
+			if strings.EqualFold(strings.TrimSpace(oldName), strings.TrimSpace(name)) {
+				ch.Webhook.Headers[name] = oldValue
+			}
+		}
+	}
+}
+
+// WebhookChannel posts notifications to URL
+type WebhookChannel struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"` // never echoed back, empty values keep the stored ones
+}
+
+// SMTPChannel mails notifications through the smtp server at Addr,
+// auth is used while Username is set
+type SMTPChannel struct {
+	Addr     string   `json:"addr"` // host:port
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"` // never echoed back, empty keeps the stored one
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Alert is a target matching the condition of a rule, it's pending until
+// the condition lasts longer than the rule's For. a resolved alert is kept
+// until its resolved notification is delivered to all the channels
+type Alert struct {
+	RuleID    string          `json:"rule_id"`
+	Kind      AlertKind       `json:"kind"`
+	Target    string          `json:"target"` // instance key, clusterID or synthetic probe key
+	Summary   string          `json:"summary"`
+	StartsAt  time.Time       `json:"starts_at"` // the condition began
+	Firing    bool            `json:"firing"`
+	LastSent  time.Time       `json:"last_sent,omitempty"` // the latest notification is sent
+	EndsAt    *time.Time      `json:"ends_at,omitempty"`   // the alert is resolved
+	Delivered map[string]bool `json:"delivered,omitempty"` // channel name to whether the latest notification is delivered
+}
+
+// Undelivered reports whether the latest notification is not delivered
+// to some channel yet
+func (alert *Alert) Undelivered() bool {
+	for _, delivered := range alert.Delivered {
+		if !delivered {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package models
+
+// DefaultMinHealthy is the percent of alive instances a cluster needs to
+// be ok while min_healthy is not set
+const DefaultMinHealthy = 50
+
+// ClusterStatus is the health rollup status of a cluster
+type ClusterStatus string
+
+const (
+	// ClusterStatusOK alive instances are no less than min_healthy
+	ClusterStatusOK ClusterStatus = "ok"
+	// ClusterStatusDegraded some instances are alive, but fewer than min_healthy
+	ClusterStatusDegraded ClusterStatus = "degraded"
+	// ClusterStatusDown no instance is alive
+	ClusterStatusDown ClusterStatus = "down"
+)
+
+// Valid reports whether s is a known cluster status
+func (s ClusterStatus) Valid() bool {
+	switch s {
+	case ClusterStatusOK, ClusterStatusDegraded, ClusterStatusDown:
+		return true
+	}
+	return false
+}
+
+// GetMinHealthy returns the min_healthy percent of the cluster
+func (o *ClusterOption) GetMinHealthy() int {
+	if o.MinHealthy == 0 {
+		return DefaultMinHealthy
+	}
+	return o.MinHealthy
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/pkg/utils"
+)
+
+// HealthKey is the root of instances health status, the tree like:
+// /health/{clusterID}/{instanceID}. it's written by the health checker only,
+// so editing an instance never races with a check result.
+const HealthKey = "/health/"
+
+// MaxTransitions is the max count of transitions kept in the health status
+const MaxTransitions = 20
+
+// HealthStatus is the runtime health of a server instance, it's written
+// only while the state, flapping, degraded, latency or passive failing
+// changes, so LastCheckTime and LastError are of the check which made
+// the latest change
+type HealthStatus struct {
+	IsAlive       bool          `json:"is_alive"`
+	LastCheckTime time.Time     `json:"last_check_time"`
+	LastError     string        `json:"last_error,omitempty"`
+	Since         time.Time     `json:"since,omitempty"`       // the checked state began
+	Flapping      bool          `json:"flapping,omitempty"`    // state changed too often within the flap window
+	Overridden    bool          `json:"overridden,omitempty"`  // IsAlive is forced by the health override
+	Transitions   []*Transition `json:"transitions,omitempty"` // latest MaxTransitions state changes, oldest first
+	Latency       *LatencyStats `json:"latency,omitempty"`     // of the recent successful probes
+	Degraded      bool          `json:"degraded,omitempty"`    // latency is well above the cluster median
+	Weight        int           `json:"weight,omitempty"`      // reduced weight while degraded, 0 means the instance weight
+	Passive       *PassiveStats `json:"passive,omitempty"`     // traffic reported by gateways
+}
+
+// LatencyStats is the percentiles of probe latency
+type LatencyStats struct {
+	P50     Duration `json:"p50"`
+	P90     Duration `json:"p90"`
+	P99     Duration `json:"p99"`
+	Samples int      `json:"samples"`
+}
+
+// Transition is a state change of an instance
+type Transition struct {
+	IsAlive bool      `json:"is_alive"` // the new state
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`  // error of the check which made the change
+	Signal  string    `json:"signal,omitempty"` // SignalActive or SignalPassive
+}
+
+// ProbeResult is a single check of an instance
+type ProbeResult struct {
+	Time       time.Time `json:"time"`
+	Latency    Duration  `json:"latency"`
+	IsAlive    bool      `json:"is_alive"`
+	StatusCode int       `json:"status_code,omitempty"` // http checks only
+	Error      string    `json:"error,omitempty"`
+}
+
+// HealthStatusKey returns the health status key of an instance
+func HealthStatusKey(clusterID, instanceID string) string {
+	return utils.Fstring("%s%s/%s", HealthKey, clusterID, instanceID)
+}
+
+// HealthStatusKeyOf converts an instance key "/clusters/{clusterID}/{instanceID}"
+// into its health status key
+func HealthStatusKeyOf(instanceKey string) string {
+	return HealthKey + strings.TrimPrefix(instanceKey, configs.ClustersKey)
+}
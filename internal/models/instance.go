@@ -0,0 +1,84 @@
+// Package models extends github.com/jademperor/common/models with the
+// fields only gateway-manager cares about. The extended types embed the
+// common ones, so they encode into the same etcd value and gateways can
+// still decode them.
+package models
+
+import (
+	"time"
+
+	cmodels "github.com/jademperor/common/models"
+)
+
+// AdminState is the administrative state of a server instance, set by
+// operators and separate from the health checker's state. gateways only
+// read IsAlive, so it's false while the instance is not enabled
+type AdminState string
+
+const (
+	// AdminStateEnabled the instance is in rotation
+	AdminStateEnabled AdminState = "enabled"
+	// AdminStateDraining the instance is being taken out of rotation
+	AdminStateDraining AdminState = "draining"
+	// AdminStateDisabled the instance is out of rotation
+	AdminStateDisabled AdminState = "disabled"
+)
+
+// Valid reports whether s is a known admin state
+func (s AdminState) Valid() bool {
+	switch s {
+	case AdminStateEnabled, AdminStateDraining, AdminStateDisabled:
+		return true
+	}
+	return false
+}
+
+// ServerInstance is cmodels.ServerInstance with manager fields
+type ServerInstance struct {
+	cmodels.ServerInstance
+	AdminState     AdminState         `json:"admin_state,omitempty"`
+	HealthCheck    *HealthCheckPolicy `json:"health_check,omitempty"`
+	HealthOverride *HealthOverride    `json:"health_override,omitempty"`
+	HealthPause    *HealthPause       `json:"health_pause,omitempty"`
+}
+
+// GetAdminState returns the admin state, instances saved before
+// the admin state existed are treated as enabled
+func (ins *ServerInstance) GetAdminState() AdminState {
+	if ins.AdminState == "" {
+		return AdminStateEnabled
+	}
+	return ins.AdminState
+}
+
+// ApplyHealth set IsAlive, the field gateways route by, to the effective
+// state: false while the instance is not enabled, the state forced by the
+// active health override, the state of status while it's health checked,
+// true otherwise. status is nil while the instance has not been checked,
+// IsAlive is kept then. returns whether IsAlive changed
+func (ins *ServerInstance) ApplyHealth(status *HealthStatus) bool {
+	isAlive := ins.IsAlive
+	switch {
+	case ins.GetAdminState() != AdminStateEnabled:
+		isAlive = false
+	case ins.HealthOverride.Active(time.Now()):
+		isAlive = ins.HealthOverride.IsAlive
+	case !ins.NeedCheckHealth:
+		isAlive = true
+	case status != nil:
+		isAlive = status.IsAlive
+	}
+	changed := ins.IsAlive != isAlive
+	ins.IsAlive = isAlive
+	return changed
+}
+
+// Routable reports whether the instance should receive requests:
+// it must be enabled and alive (or not health checked at all, and not
+// forced dead by the health override)
+func (ins *ServerInstance) Routable() bool {
+	if ins.GetAdminState() != AdminStateEnabled {
+		return false
+	}
+	return ins.IsAlive || (!ins.NeedCheckHealth && !ins.HealthOverride.Active(time.Now()))
+}
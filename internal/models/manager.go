@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	// ManagersKey is the root of gateway-manager replicas
+	ManagersKey = "/managers/"
+	// LeaderKey holds the replica which runs health checks, it expires
+	// while the leader stops refreshing it
+	LeaderKey = ManagersKey + "leader"
+	// ReplicasKey is the root of live replicas, the tree like:
+	// /managers/replicas/{id}. each key expires while its replica dies
+	ReplicasKey = ManagersKey + "replicas/"
+)
+
+// Manager is a gateway-manager replica
+type Manager struct {
+	ID        string    `json:"id"`
+	Addr      string    `json:"addr"`       // addr of the http api server
+	StartedAt time.Time `json:"started_at"` // time the replica started
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// HealthOverride forces the state of an instance until it expires, while
+// the health check endpoint is broken but the service is fine, or the reverse
+type HealthOverride struct {
+	IsAlive bool      `json:"is_alive"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// Active reports whether o is set and not expired at now
+func (o *HealthOverride) Active(now time.Time) bool {
+	return o != nil && now.Before(o.Until)
+}
+
+// HealthPause stops probing an instance or a whole cluster until it expires,
+// the state is kept as it is
+type HealthPause struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Active reports whether p is set and not expired at now
+func (p *HealthPause) Active(now time.Time) bool {
+	return p != nil && now.Before(p.Until)
+}
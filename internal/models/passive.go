@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// PassiveKey is the root of the passive health reports of gateways, the
+// tree like: /passive/{gateway}, one key holds all the instances a gateway
+// reports. the reports expire after two windows, so a gateway which stops
+// reporting is ignored.
+const PassiveKey = "/passive/"
+
+const (
+	// SignalActive the state is changed by the probes of the health checker
+	SignalActive = "active"
+	// SignalPassive the state is changed by the traffic reported by gateways
+	SignalPassive = "passive"
+)
+
+// PassiveReportBatch is the traffic of instances seen by a gateway in the
+// latest window
+type PassiveReportBatch struct {
+	Gateway   string            `json:"gateway"`
+	Window    Duration          `json:"window"`
+	Instances []*InstanceReport `json:"instances"`
+}
+
+// InstanceReport is the traffic of an instance seen by a gateway
+type InstanceReport struct {
+	ClusterID  string `json:"cluster_id"`
+	InstanceID string `json:"instance_id"`
+	Successes  int    `json:"successes"`
+	Errors     int    `json:"errors"`
+}
+
+// PassiveReport is the stored report of the instances by a gateway
+type PassiveReport struct {
+	Instances []*InstanceReport `json:"instances"`
+	Window    Duration          `json:"window"`
+	Time      time.Time         `json:"time"`
+}
+
+// PassiveStats is the traffic of an instance reported by all gateways
+type PassiveStats struct {
+	Successes int       `json:"successes"`
+	Errors    int       `json:"errors"`
+	Gateways  int       `json:"gateways"`
+	ErrorRate float64   `json:"error_rate"`
+	Failing   bool      `json:"failing"` // the error rate reaches the policy threshold
+	Time      time.Time `json:"time"`    // time of the latest report
+}
+
+// PassiveReportKey returns the key of the report by the gateway
+func PassiveReportKey(gateway string) string {
+	return PassiveKey + gateway
+}
@@ -0,0 +1,429 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	cmodels "github.com/jademperor/common/models"
+)
+
+const (
+	// DefaultRise consecutive successes to mark an instance alive
+	DefaultRise = 1
+	// DefaultFall consecutive failures to mark an instance dead
+	DefaultFall = 1
+	// DefaultInterval between two checks of an instance
+	DefaultInterval = 10 * time.Second
+	// DefaultTimeout of a check
+	DefaultTimeout = 5 * time.Second
+	// DefaultMethod of http checks
+	DefaultMethod = "GET"
+	// DefaultJitter randomizes intervals by ±5%
+	DefaultJitter = 0.1
+	// DefaultBackoffFactor multiplies the interval per check of a dead instance
+	DefaultBackoffFactor = 2.0
+	// DefaultMaxInterval caps the interval of a dead instance
+	DefaultMaxInterval = time.Minute
+	// DefaultFlapWindow is the window to count state changes
+	DefaultFlapWindow = 10 * time.Minute
+	// DefaultFlapThreshold state changes within the window mark an instance flapping
+	DefaultFlapThreshold = 5
+	// DefaultPassiveMinRequests reported requests needed to judge an instance by its error rate
+	DefaultPassiveMinRequests = 20
+	// DefaultDegradedFactor times the cluster median latency mark an instance degraded
+	DefaultDegradedFactor = 3.0
+	// Disabled turns off Jitter, DegradedWeight or PassiveErrorRate explicitly,
+	// while zero inherits the cluster defaults. it's merged into zero
+	Disabled = -1
+)
+
+var (
+	// DefaultExpectStatus of http checks
+	DefaultExpectStatus = []string{"200"}
+
+	// secretHeaders are the request headers carrying credentials by
+	// canonical name, their values are secrets like the ones of Auth
+	secretHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"X-Api-Key":           true,
+		"X-Auth-Token":        true,
+	}
+)
+
+// IsSecretHeader reports whether the value of the header name is a secret
+func IsSecretHeader(name string) bool {
+	return secretHeaders[textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))]
+}
+
+// CheckType is the protocol of health checks
+type CheckType string
+
+const (
+	// CheckHTTP requests the health check url and checks the response
+	CheckHTTP CheckType = "http"
+	// CheckTCP succeeds while the connection is established
+	CheckTCP CheckType = "tcp"
+	// CheckGRPC calls the standard grpc.health.v1 protocol
+	CheckGRPC CheckType = "grpc"
+)
+
+// Valid reports whether t is a known check type
+func (t CheckType) Valid() bool {
+	switch t {
+	case CheckHTTP, CheckTCP, CheckGRPC:
+		return true
+	}
+	return false
+}
+
+// HealthCheckPolicy defines how an instance is health checked,
+// zero fields fall back to the cluster defaults then the built-in defaults.
+// Jitter, DegradedWeight and PassiveErrorRate are Disabled to turn them off
+type HealthCheckPolicy struct {
+	Type             CheckType         `json:"type,omitempty"`                 // http, tcp or grpc
+	Target           string            `json:"target,omitempty"`               // host:port of tcp and grpc checks, defaults to the host of addr
+	GRPCService      string            `json:"grpc_service,omitempty"`         // service name of grpc checks
+	Rise             int               `json:"rise,omitempty"`                 // consecutive successes needed to become alive
+	Fall             int               `json:"fall,omitempty"`                 // consecutive failures needed to become dead
+	Interval         Duration          `json:"interval,omitempty"`             // duration between two checks
+	Jitter           float64           `json:"jitter,omitempty"`               // fraction of interval randomized, 0-1, -1 disables jitter
+	BackoffFactor    float64           `json:"backoff_factor,omitempty"`       // interval multiplier per check of a dead instance, 1 disables backoff
+	MaxInterval      Duration          `json:"max_interval,omitempty"`         // cap of the backoff interval
+	Timeout          Duration          `json:"timeout,omitempty"`              // timeout of a check
+	Method           string            `json:"method,omitempty"`               // http method
+	Body             string            `json:"body,omitempty"`                 // http request body
+	ExpectStatus     []string          `json:"expect_status,omitempty"`        // status codes like "200" or ranges like "200-299"
+	ExpectBody       string            `json:"expect_body,omitempty"`          // substring the body must contain
+	ExpectBodyRegexp string            `json:"expect_body_regexp,omitempty"`   // regexp the body must match
+	Headers          map[string]string `json:"headers,omitempty"`              // request headers, "Host" sets the request host, values of credential headers are secrets like Auth
+	FlapWindow       Duration          `json:"flap_window,omitempty"`          // window to count state changes
+	FlapThreshold    int               `json:"flap_threshold,omitempty"`       // state changes within the window to mark the instance flapping
+	DegradedFactor   float64           `json:"degraded_factor,omitempty"`      // median latency above this times the cluster median marks the instance degraded
+	DegradedWeight   int               `json:"degraded_weight,omitempty"`      // percent of weight kept while degraded, -1 keeps the whole weight
+	PassiveErrorRate float64           `json:"passive_error_rate,omitempty"`   // error rate reported by gateways to mark the instance dead, -1 disables passive checks
+	PassiveMinReqs   int               `json:"passive_min_requests,omitempty"` // reported requests needed to judge the error rate
+	TLS              *HealthCheckTLS   `json:"tls,omitempty"`                  // tls of https checks
+	Auth             *HealthCheckAuth  `json:"auth,omitempty"`                 // credentials of http checks
+}
+
+// HealthCheckTLS is the tls options of https checks, PEM encoded.
+// the client certificate is used while Cert is set
+type HealthCheckTLS struct {
+	CA                 string `json:"ca,omitempty"`   // CAs to verify the server, defaults to the system roots
+	Cert               string `json:"cert,omitempty"` // client certificate
+	Key                string `json:"key,omitempty"`  // client key, never echoed back, empty keeps the stored one
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// AuthType is the scheme of the Authorization header of http checks
+type AuthType string
+
+const (
+	// AuthBasic sends Username and Password
+	AuthBasic AuthType = "basic"
+	// AuthBearer sends Token
+	AuthBearer AuthType = "bearer"
+)
+
+// Valid reports whether t is a known auth type
+func (t AuthType) Valid() bool {
+	return t == AuthBasic || t == AuthBearer
+}
+
+// HealthCheckAuth is the credentials of http checks, it wins over the
+// Authorization header in Headers
+type HealthCheckAuth struct {
+	Type     AuthType `json:"type"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"` // never echoed back, empty keeps the stored one
+	Token    string   `json:"token,omitempty"`    // never echoed back, empty keeps the stored one
+}
+
+// Merge returns a copy of p whose zero fields are filled from defaults
+// (could be nil) and then the built-in defaults. p could be nil too.
+// headers are merged by name, the ones of p win. the Disabled fields are
+// zero in the merged policy.
+func (p *HealthCheckPolicy) Merge(defaults *HealthCheckPolicy) *HealthCheckPolicy {
+	merged := new(HealthCheckPolicy)
+	if p != nil {
+		*merged = *p
+	}
+	if defaults == nil {
+		defaults = new(HealthCheckPolicy)
+	}
+
+	if merged.Type == "" {
+		merged.Type = defaults.Type
+	}
+	if merged.Type == "" {
+		merged.Type = CheckHTTP
+	}
+	if merged.GRPCService == "" {
+		merged.GRPCService = defaults.GRPCService
+	}
+	if merged.Rise == 0 {
+		merged.Rise = defaults.Rise
+	}
+	if merged.Rise == 0 {
+		merged.Rise = DefaultRise
+	}
+	if merged.Fall == 0 {
+		merged.Fall = defaults.Fall
+	}
+	if merged.Fall == 0 {
+		merged.Fall = DefaultFall
+	}
+	if merged.Interval == 0 {
+		merged.Interval = defaults.Interval
+	}
+	if merged.Interval == 0 {
+		merged.Interval = Duration(DefaultInterval)
+	}
+	if merged.Jitter == 0 {
+		merged.Jitter = defaults.Jitter
+	}
+	if merged.Jitter == 0 {
+		merged.Jitter = DefaultJitter
+	}
+	if merged.Jitter == Disabled {
+		merged.Jitter = 0
+	}
+	if merged.BackoffFactor == 0 {
+		merged.BackoffFactor = defaults.BackoffFactor
+	}
+	if merged.BackoffFactor == 0 {
+		merged.BackoffFactor = DefaultBackoffFactor
+	}
+	if merged.MaxInterval == 0 {
+		merged.MaxInterval = defaults.MaxInterval
+	}
+	if merged.MaxInterval == 0 {
+		merged.MaxInterval = Duration(DefaultMaxInterval)
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = defaults.Timeout
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = Duration(DefaultTimeout)
+	}
+	if merged.Method == "" {
+		merged.Method = defaults.Method
+	}
+	if merged.Method == "" {
+		merged.Method = DefaultMethod
+	}
+	if merged.Body == "" {
+		merged.Body = defaults.Body
+	}
+	if len(merged.ExpectStatus) == 0 {
+		merged.ExpectStatus = defaults.ExpectStatus
+	}
+	if len(merged.ExpectStatus) == 0 {
+		merged.ExpectStatus = DefaultExpectStatus
+	}
+	if merged.ExpectBody == "" {
+		merged.ExpectBody = defaults.ExpectBody
+	}
+	if merged.ExpectBodyRegexp == "" {
+		merged.ExpectBodyRegexp = defaults.ExpectBodyRegexp
+	}
+
+	if merged.FlapWindow == 0 {
+		merged.FlapWindow = defaults.FlapWindow
+	}
+	if merged.FlapWindow == 0 {
+		merged.FlapWindow = Duration(DefaultFlapWindow)
+	}
+	if merged.FlapThreshold == 0 {
+		merged.FlapThreshold = defaults.FlapThreshold
+	}
+	if merged.FlapThreshold == 0 {
+		merged.FlapThreshold = DefaultFlapThreshold
+	}
+	if merged.DegradedFactor == 0 {
+		merged.DegradedFactor = defaults.DegradedFactor
+	}
+	if merged.DegradedFactor == 0 {
+		merged.DegradedFactor = DefaultDegradedFactor
+	}
+	if merged.DegradedWeight == 0 {
+		merged.DegradedWeight = defaults.DegradedWeight
+	}
+	if merged.DegradedWeight == Disabled {
+		merged.DegradedWeight = 0
+	}
+	if merged.PassiveErrorRate == 0 {
+		merged.PassiveErrorRate = defaults.PassiveErrorRate
+	}
+	if merged.PassiveErrorRate == Disabled {
+		merged.PassiveErrorRate = 0
+	}
+	if merged.PassiveMinReqs == 0 {
+		merged.PassiveMinReqs = defaults.PassiveMinReqs
+	}
+	if merged.PassiveMinReqs == 0 {
+		merged.PassiveMinReqs = DefaultPassiveMinRequests
+	}
+
+	if merged.TLS == nil {
+		merged.TLS = defaults.TLS
+	}
+	if merged.Auth == nil {
+		merged.Auth = defaults.Auth
+	}
+
+	headers := make(map[string]string, len(defaults.Headers)+len(merged.Headers))
+	for name, value := range defaults.Headers {
+		headers[name] = value
+	}
+	for name, value := range merged.Headers {
+		headers[name] = value
+	}
+	merged.Headers = headers
+
+	return merged
+}
+
+// Clone returns a copy of p, its tls, auth and headers are copied too,
+// so the secrets of the copy could be changed. p could be nil.
+func (p *HealthCheckPolicy) Clone() *HealthCheckPolicy {
+	if p == nil {
+		return nil
+	}
+	cloned := *p
+	if p.Headers != nil {
+		cloned.Headers = make(map[string]string, len(p.Headers))
+		for name, value := range p.Headers {
+			cloned.Headers[name] = value
+		}
+	}
+	if p.TLS != nil {
+		tls := *p.TLS
+		cloned.TLS = &tls
+	}
+	if p.Auth != nil {
+		auth := *p.Auth
+		cloned.Auth = &auth
+	}
+	return &cloned
+}
+
+// MapSecrets replace every secret of p which is set by fn of it in place,
+// the values of the secret headers included. p could be nil.
+func (p *HealthCheckPolicy) MapSecrets(fn func(v string) (string, error)) error {
+	if p == nil {
+		return nil
+	}
+	var fields []*string
+	if p.TLS != nil {
+		fields = append(fields, &p.TLS.Key)
+	}
+	if p.Auth != nil {
+		fields = append(fields, &p.Auth.Password, &p.Auth.Token)
+	}
+	for _, field := range fields {
+		if *field == "" {
+			continue
+		}
+		v, err := fn(*field)
+		if err != nil {
+			return err
+		}
+		*field = v
+	}
+
+	for name, value := range p.Headers {
+		if value == "" || !IsSecretHeader(name) {
+			continue
+		}
+		v, err := fn(value)
+		if err != nil {
+			return err
+		}
+		p.Headers[name] = v
+	}
+	return nil
+}
+
+// Redacted returns a copy of p without the secrets. p could be nil.
+func (p *HealthCheckPolicy) Redacted() *HealthCheckPolicy {
+	redacted := p.Clone()
+	redacted.MapSecrets(func(string) (string, error) { return "", nil })
+	return redacted
+}
+
+// KeepSecrets fill the empty secrets of p from old, the ones of the
+// same client certificate or the same auth type only, and the secret
+// headers of the same name
+func (p *HealthCheckPolicy) KeepSecrets(old *HealthCheckPolicy) {
+	if p == nil || old == nil {
+		return
+	}
+	if p.TLS != nil && old.TLS != nil && p.TLS.Key == "" && p.TLS.Cert == old.TLS.Cert {
+		p.TLS.Key = old.TLS.Key
+	}
+	if p.Auth != nil && old.Auth != nil && p.Auth.Type == old.Auth.Type {
+		if p.Auth.Password == "" && p.Auth.Username == old.Auth.Username {
+			p.Auth.Password = old.Auth.Password
+		}
+		if p.Auth.Token == "" {
+			p.Auth.Token = old.Auth.Token
+		}
+	}
+	for name, value := range p.Headers {
+		if value != "" || !IsSecretHeader(name) {
+			continue
+		}
+		for oldName, oldValue := range old.Headers {
+			if strings.EqualFold(strings.TrimSpace(oldName), strings.TrimSpace(name)) {
+				p.Headers[name] = oldValue
+			}
+		}
+	}
+}
+
+// Backoff returns the interval after n checks of a dead instance,
+// it grows by BackoffFactor per check and never exceeds MaxInterval,
+// or Interval if MaxInterval is less than it
+func (p *HealthCheckPolicy) Backoff(n int) time.Duration {
+	interval := float64(p.Interval)
+	max := math.Max(float64(p.MaxInterval), interval)
+	if n <= 0 || p.BackoffFactor <= 1 {
+		return p.Interval.Std()
+	}
+	return time.Duration(math.Min(interval*math.Pow(p.BackoffFactor, float64(n)), max))
+}
+
+// ParseStatusRange parse a status code like "200" or range like "200-299"
+func ParseStatusRange(s string) (lo, hi int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if lo, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("invalid status code: %s", s)
+	}
+	hi = lo
+	if len(parts) == 2 {
+		if hi, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, fmt.Errorf("invalid status code: %s", s)
+		}
+	}
+	if lo < 100 || hi > 599 || lo > hi {
+		return 0, 0, fmt.Errorf("invalid status range: %s", s)
+	}
+	return lo, hi, nil
+}
+
+// ClusterOption is cmodels.ClusterOption with manager fields
+type ClusterOption struct {
+	cmodels.ClusterOption
+	HealthCheck  *HealthCheckPolicy `json:"health_check,omitempty"`  // defaults of instances
+	HealthPause  *HealthPause       `json:"health_pause,omitempty"`  // pause probing all instances
+	MinHealthy   int                `json:"min_healthy,omitempty"`   // percent of alive instances to be ok, 0 means DefaultMinHealthy
+	HealthShadow *ShadowMode        `json:"health_shadow,omitempty"` // probe without writing the health status
+}
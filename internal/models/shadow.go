@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jademperor/common/configs"
+	"github.com/jademperor/common/pkg/utils"
+)
+
+const (
+	// ShadowKey holds the global shadow mode, all clusters are in shadow
+	// mode while it's active
+	ShadowKey = "/healthchecking/shadow"
+	// ShadowHealthKey is the root of the health status reached in shadow
+	// mode, the tree like: /shadow/{clusterID}/{instanceID}
+	ShadowHealthKey = "/shadow/"
+)
+
+// ShadowMode keeps probing the instances of a cluster or all clusters
+// without writing their health status until it expires, so IsAlive is kept
+// as it is. the status reached is written aside to be compared.
+type ShadowMode struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Active reports whether m is set and not expired at now
+func (m *ShadowMode) Active(now time.Time) bool {
+	return m != nil && now.Before(m.Until)
+}
+
+// ShadowStatusKey returns the shadow health status key of an instance
+func ShadowStatusKey(clusterID, instanceID string) string {
+	return utils.Fstring("%s%s/%s", ShadowHealthKey, clusterID, instanceID)
+}
+
+// ShadowStatusKeyOf converts an instance key "/clusters/{clusterID}/{instanceID}"
+// into its shadow health status key
+func ShadowStatusKeyOf(instanceKey string) string {
+	return ShadowHealthKey + strings.TrimPrefix(instanceKey, configs.ClustersKey)
+}
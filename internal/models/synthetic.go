@@ -0,0 +1,84 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/common/pkg/utils"
+)
+
+const (
+	// SyntheticsKey is the root of synthetic probes, the tree like:
+	// /synthetics/{probeID}
+	SyntheticsKey = "/synthetics/"
+	// SyntheticHealthKey is the root of the health status of synthetic
+	// probes, the tree like: /synthetic_health/{probeID}
+	SyntheticHealthKey = "/synthetic_health/"
+)
+
+// SyntheticProbe sends a defined request through a gateway to an api or
+// a routing on a schedule, so the whole path of the request is checked:
+// routing, rewrite and combination. it's checked like an instance, by Check
+// without the cluster defaults.
+type SyntheticProbe struct {
+	Idx        string             `json:"idx"`
+	Name       string             `json:"name"`
+	GatewayURL string             `json:"gateway_url"`          // base url of the gateway, like http://gateway:8080
+	APIID      string             `json:"api_id,omitempty"`     // the api requested, either api_id or routing_id is set
+	RoutingID  string             `json:"routing_id,omitempty"` // the routing requested
+	Path       string             `json:"path,omitempty"`       // replaces the path of the api to fill its params, or follows the prefix of the routing
+	Check      *HealthCheckPolicy `json:"check,omitempty"`      // request and assertions, http only, the method defaults to the one of the api
+}
+
+// Request returns the url requested and the policy of the probe,
+// api or routing is the one the probe targets, nil while not found
+func (p *SyntheticProbe) Request(api *cmodels.API, routing *cmodels.Routing) (string, *HealthCheckPolicy, error) {
+	policy := p.Check.Clone()
+	if policy == nil {
+		policy = new(HealthCheckPolicy)
+	}
+
+	var path string
+	switch {
+	case p.APIID != "":
+		if api == nil {
+			return "", nil, fmt.Errorf("api %s not found", p.APIID)
+		}
+		path = api.Path
+		if p.Path != "" {
+			path = p.Path
+		}
+		if policy.Method == "" {
+			policy.Method = api.Method
+		}
+	case p.RoutingID != "":
+		if routing == nil {
+			return "", nil, fmt.Errorf("routing %s not found", p.RoutingID)
+		}
+		path = routing.Prefix
+		if p.Path != "" {
+			path = strings.TrimSuffix(routing.Prefix, "/") + p.Path
+		}
+	default:
+		return "", nil, errors.New("api_id or routing_id is required")
+	}
+	return strings.TrimSuffix(p.GatewayURL, "/") + path, policy, nil
+}
+
+// SyntheticKey returns the key of a synthetic probe
+func SyntheticKey(probeID string) string {
+	return SyntheticsKey + probeID
+}
+
+// SyntheticStatusKey returns the health status key of a synthetic probe
+func SyntheticStatusKey(probeID string) string {
+	return utils.Fstring("%s%s", SyntheticHealthKey, probeID)
+}
+
+// SyntheticStatusKeyOf converts a synthetic probe key "/synthetics/{probeID}"
+// into its health status key
+func SyntheticStatusKeyOf(key string) string {
+	return SyntheticHealthKey + strings.TrimPrefix(key, SyntheticsKey)
+}
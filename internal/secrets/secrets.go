@@ -0,0 +1,147 @@
+// Package secrets seals the credentials of health checks and alerting
+// channels before they're stored, so they're never kept in plain text in etcd. the sealing key
+// is derived from the key file shared by all replicas.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// sealedPrefix marks a sealed value
+const sealedPrefix = "sealed:v1:"
+
+var (
+	// ErrNoKey is returned while sealing without a key
+	ErrNoKey = errors.New("secrets: no key, start with -secret-key-file to store credentials")
+
+	aead      cipher.AEAD
+	aeadMutex sync.RWMutex
+)
+
+// Init derive the sealing key from the content of keyFile,
+// empty keyFile leaves secrets unable to be sealed or opened
+func Init(keyFile string) error {
+	if keyFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	return SetKey(data)
+}
+
+// SetKey derive the sealing key from key
+func SetKey(key []byte) error {
+	key = []byte(strings.TrimSpace(string(key)))
+	if len(key) == 0 {
+		return errors.New("secrets: empty key")
+	}
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	aeadMutex.Lock()
+	aead = gcm
+	aeadMutex.Unlock()
+	return nil
+}
+
+func getAEAD() cipher.AEAD {
+	aeadMutex.RLock()
+	defer aeadMutex.RUnlock()
+	return aead
+}
+
+// IsSealed reports whether v is sealed
+func IsSealed(v string) bool {
+	return strings.HasPrefix(v, sealedPrefix)
+}
+
+// Seal returns v sealed, sealed or empty v is returned as it is
+func Seal(v string) (string, error) {
+	if v == "" || IsSealed(v) {
+		return v, nil
+	}
+	gcm := getAEAD()
+	if gcm == nil {
+		return "", ErrNoKey
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(v), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the plain text of sealed v, v not sealed is returned as it is
+func Open(v string) (string, error) {
+	if !IsSealed(v) {
+		return v, nil
+	}
+	gcm := getAEAD()
+	if gcm == nil {
+		return "", ErrNoKey
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("secrets: sealed value is too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("secrets: sealed by another key or corrupted")
+	}
+	return string(plain), nil
+}
+
+// SealPolicy seal the secrets of policy in place, policy could be nil
+func SealPolicy(policy *models.HealthCheckPolicy) error {
+	return policy.MapSecrets(Seal)
+}
+
+// OpenPolicy returns a copy of policy with the secrets opened,
+// policy could be nil
+func OpenPolicy(policy *models.HealthCheckPolicy) (*models.HealthCheckPolicy, error) {
+	opened := policy.Clone()
+	if err := opened.MapSecrets(Open); err != nil {
+		return nil, err
+	}
+	return opened, nil
+}
+
+// SealAlerting seal the secrets of the alerting channels in place
+func SealAlerting(cfg *models.AlertingConfig) error {
+	return cfg.MapSecrets(Seal)
+}
+
+// OpenChannel returns a copy of the alerting channel with the secrets opened
+func OpenChannel(ch *models.AlertChannel) (*models.AlertChannel, error) {
+	opened := ch.Clone()
+	if err := opened.MapSecrets(Open); err != nil {
+		return nil, err
+	}
+	return opened, nil
+}
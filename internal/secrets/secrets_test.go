@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func Test_SealPolicy(t *testing.T) {
+	if err := SetKey([]byte("test-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &models.HealthCheckPolicy{
+		TLS:     &models.HealthCheckTLS{Cert: "cert", Key: "key"},
+		Auth:    &models.HealthCheckAuth{Type: models.AuthBasic, Username: "user", Password: "pass"},
+		Headers: map[string]string{"x-api-key": "api-key", "X-Trace": "trace"},
+	}
+	if err := SealPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(policy.TLS.Key) || !IsSealed(policy.Auth.Password) || !IsSealed(policy.Headers["x-api-key"]) {
+		t.Fatalf("secrets are not sealed: %+v %+v %v", policy.TLS, policy.Auth, policy.Headers)
+	}
+	if policy.Headers["X-Trace"] != "trace" {
+		t.Fatalf("not secret header is sealed: %v", policy.Headers)
+	}
+	if policy.TLS.Cert != "cert" || policy.Auth.Username != "user" || policy.Auth.Token != "" {
+		t.Fatalf("not secrets are changed: %+v %+v", policy.TLS, policy.Auth)
+	}
+
+	// sealing again keeps the sealed values
+	key := policy.TLS.Key
+	if err := SealPolicy(policy); err != nil || policy.TLS.Key != key {
+		t.Fatalf("sealed again: %v, %s != %s", err, policy.TLS.Key, key)
+	}
+
+	opened, err := OpenPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.TLS.Key != "key" || opened.Auth.Password != "pass" || opened.Headers["x-api-key"] != "api-key" {
+		t.Fatalf("opened: %+v %+v %v", opened.TLS, opened.Auth, opened.Headers)
+	}
+	if !IsSealed(policy.TLS.Key) || !IsSealed(policy.Headers["x-api-key"]) {
+		t.Fatal("OpenPolicy changed the sealed policy")
+	}
+
+	// another key can't open
+	if err := SetKey([]byte("another-key")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPolicy(policy); err == nil {
+		t.Fatal("opened by another key")
+	}
+}
+
+func Test_Redacted(t *testing.T) {
+	policy := &models.HealthCheckPolicy{
+		TLS:     &models.HealthCheckTLS{Cert: "cert", Key: "key"},
+		Auth:    &models.HealthCheckAuth{Type: models.AuthBearer, Token: "token"},
+		Headers: map[string]string{"Cookie": "session=1", "X-Trace": "trace"},
+	}
+	redacted := policy.Redacted()
+	if redacted.TLS.Key != "" || redacted.Auth.Token != "" || redacted.TLS.Cert != "cert" {
+		t.Fatalf("redacted: %+v %+v", redacted.TLS, redacted.Auth)
+	}
+	if redacted.Headers["Cookie"] != "" || redacted.Headers["X-Trace"] != "trace" {
+		t.Fatalf("redacted headers: %v", redacted.Headers)
+	}
+	if policy.TLS.Key != "key" || policy.Auth.Token != "token" || policy.Headers["Cookie"] != "session=1" {
+		t.Fatal("Redacted changed the policy")
+	}
+
+	// empty secrets are kept from the stored ones
+	redacted.Headers = map[string]string{"cookie": ""}
+	redacted.KeepSecrets(policy)
+	if redacted.TLS.Key != "key" || redacted.Auth.Token != "token" || redacted.Headers["cookie"] != "session=1" {
+		t.Fatalf("kept: %+v %+v %v", redacted.TLS, redacted.Auth, redacted.Headers)
+	}
+	changed := &models.HealthCheckPolicy{TLS: &models.HealthCheckTLS{Cert: "another"}}
+	if changed.KeepSecrets(policy); changed.TLS.Key != "" {
+		t.Fatal("key of another certificate is kept")
+	}
+}
+
+func Test_SealAlerting(t *testing.T) {
+	if err := SetKey([]byte("test-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &models.AlertingConfig{Channels: []*models.AlertChannel{
+		{Name: "hook", Type: models.ChannelWebhook, Webhook: &models.WebhookChannel{URL: "http://hook", Headers: map[string]string{"X-Token": "token"}}},
+		{Name: "mail", Type: models.ChannelSMTP, SMTP: &models.SMTPChannel{Addr: "smtp:25", Username: "user", Password: "pass"}},
+	}}
+	if err := SealAlerting(cfg); err != nil {
+		t.Fatal(err)
+	}
+	hook, mail := cfg.Channel("hook"), cfg.Channel("mail")
+	if !IsSealed(hook.Webhook.Headers["X-Token"]) || !IsSealed(mail.SMTP.Password) {
+		t.Fatalf("secrets are not sealed: %v %+v", hook.Webhook.Headers, mail.SMTP)
+	}
+
+	opened, err := OpenChannel(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Webhook.Headers["X-Token"] != "token" || !IsSealed(hook.Webhook.Headers["X-Token"]) {
+		t.Fatalf("opened: %v, sealed: %v", opened.Webhook.Headers, hook.Webhook.Headers)
+	}
+
+	redacted := cfg.Redacted()
+	if redacted.Channel("hook").Webhook.Headers["X-Token"] != "" || redacted.Channel("mail").SMTP.Password != "" {
+		t.Fatalf("redacted: %v %+v", redacted.Channel("hook").Webhook.Headers, redacted.Channel("mail").SMTP)
+	}
+	if !IsSealed(hook.Webhook.Headers["X-Token"]) || !IsSealed(mail.SMTP.Password) {
+		t.Fatal("Redacted changed the config")
+	}
+
+	// empty secrets are kept from the stored ones
+	redacted.Channel("hook").Webhook.Headers = map[string]string{"x-token": ""}
+	redacted.KeepSecrets(cfg)
+	if redacted.Channel("hook").Webhook.Headers["x-token"] != hook.Webhook.Headers["X-Token"] ||
+		redacted.Channel("mail").SMTP.Password != mail.SMTP.Password {
+		t.Fatalf("kept: %v %+v", redacted.Channel("hook").Webhook.Headers, redacted.Channel("mail").SMTP)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
+)
+
+// GetAlertingConfig load the alerting config, it's empty while not set
+func GetAlertingConfig() (*models.AlertingConfig, error) {
+	cfg := &models.AlertingConfig{
+		Rules:    make([]*models.AlertRule, 0),
+		Channels: make([]*models.AlertChannel, 0),
+	}
+	v, err := store.Get(models.AlertingConfigKey)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := etcdutils.Decode(v, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetAlertingConfig replace the alerting config, the empty secrets of a
+// channel are kept from the stored one, and the secrets are sealed
+func SetAlertingConfig(cfg *models.AlertingConfig) error {
+	old, err := GetAlertingConfig()
+	if err != nil {
+		return err
+	}
+	cfg.KeepSecrets(old)
+	if err := secrets.SealAlerting(cfg); err != nil {
+		return err
+	}
+
+	data, err := etcdutils.Encode(cfg)
+	if err != nil {
+		return err
+	}
+	return store.Set(models.AlertingConfigKey, string(data), -1)
+}
+
+// GetAlerts load the alerts pending or firing, the map key is the
+// fingerprint of the alert
+func GetAlerts() (map[string]*models.Alert, error) {
+	alerts := make(map[string]*models.Alert)
+	v, err := store.Get(models.AlertsKey)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return alerts, nil
+		}
+		return nil, err
+	}
+
+	if err := etcdutils.Decode(v, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// SetAlerts save the alerts pending or firing
+func SetAlerts(alerts map[string]*models.Alert) error {
+	data, err := etcdutils.Encode(alerts)
+	if err != nil {
+		return err
+	}
+	return store.Set(models.AlertsKey, string(data), -1)
+}
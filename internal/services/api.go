@@ -4,6 +4,8 @@ import (
 	"context"
 	// "encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/jademperor/common/configs"
 	"github.com/jademperor/common/etcdutils"
@@ -26,6 +28,54 @@ func AddAPI(api *models.API) (string, error) {
 	return apiID, nil
 }
 
+// CloneAPI copy an api config and all its combinations with a new id,
+// override is called on the copy before saving and could reject it.
+// the copy is rejected while an api serves its method and path already,
+// so the path or method must be overridden
+func CloneAPI(apiID string, override func(api *models.API) error) (string, error) {
+	api, err := GetAPIInfo(apiID)
+	if err != nil {
+		return "", err
+	}
+	if override != nil {
+		if err := override(api); err != nil {
+			return "", err
+		}
+	}
+
+	dupID, err := apiIDOfRoute(api.Method, api.Path)
+	if err != nil {
+		return "", err
+	}
+	if dupID != "" {
+		return "", fmt.Errorf("api %s serves %s %s already, override the path or method", dupID, api.Method, api.Path)
+	}
+	return AddAPI(api)
+}
+
+// apiIDOfRoute returns id of the api serving method and path, empty if none
+func apiIDOfRoute(method, path string) (string, error) {
+	resp, err := store.Kapi.Get(context.Background(), configs.APIsKey, nil)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		api := new(models.API)
+		if err := etcdutils.Decode(node.Value, api); err != nil {
+			logger.Logger.Errorf("apiIDOfRoute got err: %v", err)
+			continue
+		}
+		if api.Path == path && strings.EqualFold(api.Method, method) {
+			return api.Idx, nil
+		}
+	}
+	return "", nil
+}
+
 // DelAPI ...
 func DelAPI(apiID string) error {
 	apiKey := utils.Fstring("%s%s", configs.APIsKey, apiID)
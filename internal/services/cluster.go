@@ -2,31 +2,176 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/jademperor/common/configs"
 	"github.com/jademperor/common/etcdutils"
-	"github.com/jademperor/common/models"
+	cmodels "github.com/jademperor/common/models"
 	"github.com/jademperor/common/pkg/utils"
 	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"github.com/jademperor/gateway-manager/internal/secrets"
+	"go.etcd.io/etcd/client"
 )
 
 // Cluster service layer
 type Cluster struct {
-	Idx       string                   `json:"idx"`
-	Name      string                   `json:"name"`
-	Instances []*models.ServerInstance `json:"instances"`
+	Idx          string                    `json:"idx"`
+	Name         string                    `json:"name"`
+	HealthCheck  *models.HealthCheckPolicy `json:"health_check,omitempty"`
+	HealthPause  *models.HealthPause       `json:"health_pause,omitempty"`
+	MinHealthy   int                       `json:"min_healthy,omitempty"`
+	HealthShadow *models.ShadowMode        `json:"health_shadow,omitempty"`
+	Health       *ClusterHealth            `json:"health"`
+	Instances    []*Instance               `json:"instances"`
+}
+
+// ClusterHealth is the health rollup of a cluster. draining and disabled
+// instances are not alive, so taking too many out of rotation degrades
+// the cluster too
+type ClusterHealth struct {
+	Total        int                  `json:"total"`
+	Alive        int                  `json:"alive"` // routable instances
+	Draining     int                  `json:"draining"`
+	Disabled     int                  `json:"disabled"`
+	Degraded     int                  `json:"degraded"` // alive instances with high latency
+	AlivePercent float64              `json:"alive_percent"`
+	MinHealthy   int                  `json:"min_healthy"` // percent, the cluster option or the default
+	Status       models.ClusterStatus `json:"status"`
+}
+
+// newClusterHealth rollup the health of instances, the cluster is down
+// while no instance is alive, degraded while fewer than minHealthy percent
+// of instances are alive
+func newClusterHealth(instances []*Instance, minHealthy int) *ClusterHealth {
+	health := &ClusterHealth{Total: len(instances), MinHealthy: minHealthy}
+	for _, ins := range instances {
+		switch ins.GetAdminState() {
+		case models.AdminStateDraining:
+			health.Draining++
+		case models.AdminStateDisabled:
+			health.Disabled++
+		}
+		if ins.Routable {
+			health.Alive++
+			if ins.Degraded {
+				health.Degraded++
+			}
+		}
+	}
+	if health.Total > 0 {
+		health.AlivePercent = float64(health.Alive) * 100 / float64(health.Total)
+	}
+
+	switch {
+	case health.Alive == 0:
+		health.Status = models.ClusterStatusDown
+	case health.AlivePercent < float64(minHealthy):
+		health.Status = models.ClusterStatusDegraded
+	default:
+		health.Status = models.ClusterStatusOK
+	}
+	return health
+}
+
+// Instance service layer, the server instance with its health status
+// and effective state
+type Instance struct {
+	*models.ServerInstance
+	Health          *models.HealthStatus `json:"health,omitempty"`
+	Routable        bool                 `json:"routable"`
+	Overridden      bool                 `json:"overridden"`       // the health override is active
+	Paused          bool                 `json:"paused"`           // probing is paused on the instance or the cluster
+	Degraded        bool                 `json:"degraded"`         // latency is well above the cluster median
+	EffectiveWeight int                  `json:"effective_weight"` // weight reduced while degraded
+}
+
+// newInstance merge the health status into instance,
+// status could be nil if the instance has not been checked.
+// an active health override wins over the status, and the secrets
+// of the health check are redacted
+func newInstance(ins *models.ServerInstance, status *models.HealthStatus) *Instance {
+	now := time.Now()
+	ins.HealthCheck = ins.HealthCheck.Redacted()
+	if status != nil {
+		ins.IsAlive = status.IsAlive
+		// the transitions are served by the instance health api only
+		brief := *status
+		brief.Transitions = nil
+		status = &brief
+	}
+	overridden := ins.HealthOverride.Active(now)
+	if overridden {
+		ins.IsAlive = ins.HealthOverride.IsAlive
+	}
+	instance := &Instance{
+		ServerInstance:  ins,
+		Health:          status,
+		Routable:        ins.Routable(),
+		Overridden:      overridden,
+		Paused:          ins.HealthPause.Active(now),
+		EffectiveWeight: ins.Weight,
+	}
+	if status != nil {
+		instance.Degraded = status.Degraded
+		if status.Weight > 0 {
+			instance.EffectiveWeight = status.Weight
+		}
+	}
+	return instance
+}
+
+// newCluster create the cluster view with its health rollup,
+// the instances are paused while the cluster is paused, and the
+// secrets of the health check defaults are redacted
+func newCluster(clusterID string, clsOpt *models.ClusterOption, instances []*Instance) *Cluster {
+	if clsOpt.HealthPause.Active(time.Now()) {
+		for _, ins := range instances {
+			ins.Paused = true
+		}
+	}
+	return &Cluster{
+		Idx:          clusterID,
+		Name:         clsOpt.Name,
+		HealthCheck:  clsOpt.HealthCheck.Redacted(),
+		HealthPause:  clsOpt.HealthPause,
+		MinHealthy:   clsOpt.MinHealthy,
+		HealthShadow: clsOpt.HealthShadow,
+		Health:       newClusterHealth(instances, clsOpt.GetMinHealthy()),
+		Instances:    instances,
+	}
 }
 
 // NewCluster generate a new cluster
 func NewCluster(name string, srvInstances []*models.ServerInstance) (clusterID string, err error) {
+	clsOpt := &models.ClusterOption{
+		ClusterOption: cmodels.ClusterOption{
+			Name: name,
+		},
+	}
+	return newClusterOf(clsOpt, srvInstances)
+}
+
+// newClusterOf save a new cluster with the option and instances,
+// the secrets sealed already are kept as they are
+func newClusterOf(clsOpt *models.ClusterOption, srvInstances []*models.ServerInstance) (clusterID string, err error) {
 	clusterID = utils.UUID()
+	clsOpt.Idx = clusterID
 
-	clsOpt := models.ClusterOption{
-		Idx:  clusterID,
-		Name: name,
+	// seal the secrets before any write
+	if err = secrets.SealPolicy(clsOpt.HealthCheck); err != nil {
+		return "", err
 	}
+	for _, instance := range srvInstances {
+		if err = secrets.SealPolicy(instance.HealthCheck); err != nil {
+			return "", err
+		}
+	}
+
 	clusterKey := utils.Fstring("%s%s", configs.ClustersKey, clusterID)
 	clusterOptKey := utils.Fstring("%s/%s", clusterKey, configs.ClusterOptionsKey)
 	data, err := etcdutils.Encode(clsOpt)
@@ -45,34 +190,174 @@ func NewCluster(name string, srvInstances []*models.ServerInstance) (clusterID s
 		instanceKey := utils.Fstring("%s/%s", clusterKey, instanceID)
 		instance.ClusterID = clusterID
 		instance.Idx = instanceID
+		if instance.AdminState == "" {
+			instance.AdminState = models.AdminStateEnabled
+		}
+		instance.ApplyHealth(nil)
 		data, _ := etcdutils.Encode(instance)
 		_ = store.Set(instanceKey, string(data), -1)
 	}
 	return
 }
 
+// CloneCluster copy a cluster and all its instances into a new cluster,
+// the instances get new ids and their health is checked again.
+// the stored option and instances are copied, so are their sealed secrets.
+// override is called on the copied option, named "{name}-clone", before
+// saving and could reject it. the pauses are holds of the source only,
+// so they're not copied, and the instances are enabled without override
+// like new ones
+func CloneCluster(clusterID string, override func(clsOpt *models.ClusterOption) error) (string, error) {
+	clsOpt, err := getClusterOption(clusterID)
+	if err != nil {
+		return "", err
+	}
+	srvInstances, err := getClusterInstances(clusterID)
+	if err != nil {
+		return "", err
+	}
+
+	cloned := *clsOpt
+	cloned.Name = clsOpt.Name + "-clone"
+	cloned.HealthCheck = clsOpt.HealthCheck.Clone()
+	cloned.HealthPause = nil
+	if clsOpt.HealthShadow != nil {
+		shadow := *clsOpt.HealthShadow
+		cloned.HealthShadow = &shadow
+	}
+	if override != nil {
+		if err := override(&cloned); err != nil {
+			return "", err
+		}
+	}
+	for _, srvIns := range srvInstances {
+		srvIns.IsAlive = false
+		srvIns.AdminState = models.AdminStateEnabled
+		srvIns.HealthOverride = nil
+		srvIns.HealthPause = nil
+	}
+	return newClusterOf(&cloned, srvInstances)
+}
+
 // DelCluster del a cluster using store
 func DelCluster(clusterID string) error {
 	clusterKey := utils.Fstring("%s%s", configs.ClustersKey, clusterID)
-	return store.Delete(clusterKey, true)
+	if err := store.Delete(clusterKey, true); err != nil {
+		return err
+	}
+	delHealthStatus(clusterID, "")
+	return nil
 }
 
 // UpdateClusterInfo update the cluster info (ClusterOption)
 func UpdateClusterInfo(clusterID, name string) error {
-	clusterOptKey := utils.Fstring("%s%s/%s",
-		configs.ClustersKey, clusterID, configs.ClusterOptionsKey)
+	return updateClusterOption(clusterID, func(clsOpt *models.ClusterOption) error {
+		clsOpt.Name = name
+		return nil
+	})
+}
 
-	clsOpt := &models.ClusterOption{
-		Idx:  clusterID,
-		Name: name,
+// SetClusterHealthCheck set the default health check policy of
+// instances in the cluster
+func SetClusterHealthCheck(clusterID string, policy *models.HealthCheckPolicy) error {
+	return updateClusterOption(clusterID, func(clsOpt *models.ClusterOption) error {
+		// policy is sealed in place, it's the same while retried
+		if err := sealPolicy(policy, clsOpt.HealthCheck); err != nil {
+			return err
+		}
+		clsOpt.HealthCheck = policy
+		return nil
+	})
+}
+
+// SetClusterMinHealthy set the percent of alive instances the cluster
+// needs to be ok, 0 means the default
+func SetClusterMinHealthy(clusterID string, minHealthy int) error {
+	return updateClusterOption(clusterID, func(clsOpt *models.ClusterOption) error {
+		clsOpt.MinHealthy = minHealthy
+		return nil
+	})
+}
+
+// SetClusterHealthPause pause probing all instances in the cluster,
+// nil resumes
+func SetClusterHealthPause(clusterID string, pause *models.HealthPause) error {
+	return updateClusterOption(clusterID, func(clsOpt *models.ClusterOption) error {
+		clsOpt.HealthPause = pause
+		return nil
+	})
+}
+
+// sealPolicy keep the empty secrets of policy from the stored policy,
+// and seal the new ones. policy and stored could be nil
+func sealPolicy(policy, stored *models.HealthCheckPolicy) error {
+	policy.KeepSecrets(stored)
+	return secrets.SealPolicy(policy)
+}
+
+// SetClusterHealthShadow put the cluster in shadow mode, nil leaves it
+func SetClusterHealthShadow(clusterID string, mode *models.ShadowMode) error {
+	return updateClusterOption(clusterID, func(clsOpt *models.ClusterOption) error {
+		clsOpt.HealthShadow = mode
+		return nil
+	})
+}
+
+func clusterOptionKeyOf(clusterID string) string {
+	return utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, configs.ClusterOptionsKey)
+}
+
+func getClusterOption(clusterID string) (*models.ClusterOption, error) {
+	v, err := store.Get(clusterOptionKeyOf(clusterID))
+	if err != nil {
+		return nil, err
 	}
-	data, _ := etcdutils.Encode(clsOpt)
 
-	return store.Set(clusterOptKey, string(data), -1)
+	clsOpt := new(models.ClusterOption)
+	if err = etcdutils.Decode(v, clsOpt); err != nil {
+		return nil, err
+	}
+	clsOpt.Idx = clusterID
+	return clsOpt, nil
 }
 
-// GetAllClusters ...
-func GetAllClusters() ([]*Cluster, error) {
+// updateClusterOption apply update to the stored cluster option by
+// compare-and-swap, so the concurrent updates of different fields never
+// overwrite each other. it's retried while the option has been updated
+// meanwhile.
+func updateClusterOption(clusterID string, update func(clsOpt *models.ClusterOption) error) error {
+	clusterOptKey := clusterOptionKeyOf(clusterID)
+	for retry := 0; retry < maxCASRetries; retry++ {
+		resp, err := store.Kapi.Get(context.Background(), clusterOptKey, nil)
+		if err != nil {
+			return err
+		}
+		clsOpt := new(models.ClusterOption)
+		if err = etcdutils.Decode(resp.Node.Value, clsOpt); err != nil {
+			return err
+		}
+		clsOpt.Idx = clusterID
+		if err = update(clsOpt); err != nil {
+			return err
+		}
+
+		data, err := etcdutils.Encode(clsOpt)
+		if err != nil {
+			return err
+		}
+		opts := &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex}
+		if _, err = store.Kapi.Set(context.Background(), clusterOptKey, string(data), opts); err == nil {
+			return nil
+		} else if !isCompareFailed(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("cluster %s is updated concurrently, try again", clusterID)
+}
+
+// GetAllClusters load all clusters, only the ones of status are
+// returned while status is not empty
+func GetAllClusters(status models.ClusterStatus) ([]*Cluster, error) {
 	var (
 		clusterCfgs = make([]*Cluster, 0)
 	)
@@ -86,7 +371,8 @@ func GetAllClusters() ([]*Cluster, error) {
 		clusterID := strings.Split(clusterNode.Key, "/")[2]
 		logger.Logger.Infof("find cluster %s", clusterID)
 		clsOpt := new(models.ClusterOption)
-		srvInses := make([]*models.ServerInstance, 0)
+		srvInses := make([]*Instance, 0)
+		statuses := getHealthStatuses(clusterID)
 		if resp2, err := store.Kapi.Get(context.Background(), clusterNode.Key, nil); err == nil && resp2.Node.Dir {
 			for _, srvInsNode := range resp2.Node.Nodes {
 				// skip the option node
@@ -102,17 +388,17 @@ func GetAllClusters() ([]*Cluster, error) {
 					logger.Logger.Error(err)
 					continue
 				}
-				srvInses = append(srvInses, srvInsCfg)
+				srvInses = append(srvInses, newInstance(srvInsCfg, statuses[srvInsCfg.Idx]))
 			}
 		} else {
 			logger.Logger.Errorf("store.Kapi.Get got an err: %v", err)
 		}
 
-		clusterCfgs = append(clusterCfgs, &Cluster{
-			Idx:       clusterID,
-			Name:      clsOpt.Name,
-			Instances: srvInses,
-		})
+		cls := newCluster(clusterID, clsOpt, srvInses)
+		if status != "" && cls.Health.Status != status {
+			continue
+		}
+		clusterCfgs = append(clusterCfgs, cls)
 	}
 
 	return clusterCfgs, nil
@@ -172,7 +458,8 @@ func GetClusterInfo(clusterID string) (*Cluster, error) {
 	}
 
 	clsOpt := new(models.ClusterOption)
-	srvInses := make([]*models.ServerInstance, 0)
+	srvInses := make([]*Instance, 0)
+	statuses := getHealthStatuses(clusterID)
 	// load server instance ...
 	for _, srvInsNode := range resp.Node.Nodes {
 		// skip the option node
@@ -188,64 +475,124 @@ func GetClusterInfo(clusterID string) (*Cluster, error) {
 			logger.Logger.Error(err)
 			continue
 		}
-		srvInses = append(srvInses, srvInsCfg)
+		srvInses = append(srvInses, newInstance(srvInsCfg, statuses[srvInsCfg.Idx]))
 	}
 
-	return &Cluster{
-		Idx:       clusterID,
-		Name:      clsOpt.Name,
-		Instances: srvInses,
-	}, nil
+	return newCluster(clusterID, clsOpt, srvInses), nil
 }
 
 // AddClusterInstance add a instance into the cluster
 func AddClusterInstance(clusterID, name, addr string,
 	weight int, need bool, hcURL string) (instanceID string, err error) {
 	instanceID = utils.UUID()
-	instanceKey := utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
-
 	srvInstance := &models.ServerInstance{
-		Idx:             instanceID,
-		Name:            name,
-		Addr:            addr,
-		ClusterID:       clusterID,
-		Weight:          weight,
-		NeedCheckHealth: need,
-		HealthCheckURL:  hcURL,
+		ServerInstance: cmodels.ServerInstance{
+			Idx:             instanceID,
+			Name:            name,
+			Addr:            addr,
+			ClusterID:       clusterID,
+			Weight:          weight,
+			NeedCheckHealth: need,
+			HealthCheckURL:  hcURL,
+		},
+		AdminState: models.AdminStateEnabled,
 	}
-	data, _ := etcdutils.Encode(srvInstance)
-
-	err = store.Set(instanceKey, string(data), -1)
+	err = addClusterInstance(srvInstance)
 	return
 }
 
 // DelClusterInstance del a instance from a cluster instance sets
 func DelClusterInstance(clusterID, instanceID string) error {
 	instanceKey := utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
-	return store.Delete(instanceKey, false)
+	if err := store.Delete(instanceKey, false); err != nil {
+		return err
+	}
+	delHealthStatus(clusterID, instanceID)
+	return nil
 }
 
-// UpdateClusterInstanceInfo update a instance info in a cluster sets
+// UpdateClusterInstanceInfo update a instance info in a cluster sets,
+// the admin state is kept as it is
 func UpdateClusterInstanceInfo(clusterID, instanceID, name, addr string,
 	weight int, need bool, hcURL string) error {
-	instanceKey := utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
-	srvInstance := &models.ServerInstance{
-		Idx:             instanceID,
-		Name:            name,
-		Addr:            addr,
-		ClusterID:       clusterID,
-		Weight:          weight,
-		NeedCheckHealth: need,
-		HealthCheckURL:  hcURL,
+	_, err := updateClusterInstance(clusterID, instanceID, func(instance *models.ServerInstance) error {
+		instance.Name = name
+		instance.Addr = addr
+		instance.Weight = weight
+		instance.NeedCheckHealth = need
+		instance.HealthCheckURL = hcURL
+		return nil
+	})
+	return err
+}
+
+// SetClusterInstanceAdminState change the admin state of a instance
+func SetClusterInstanceAdminState(clusterID, instanceID string, state models.AdminState) error {
+	if !state.Valid() {
+		return fmt.Errorf("invalid admin state: %s", state)
 	}
-	data, _ := etcdutils.Encode(srvInstance)
+	_, err := updateClusterInstance(clusterID, instanceID, func(instance *models.ServerInstance) error {
+		instance.AdminState = state
+		return nil
+	})
+	return err
+}
 
-	store.Set(instanceKey, string(data), -1)
-	return nil
+// SetClusterInstanceHealthCheck set the health check policy of a instance,
+// nil means using the cluster defaults
+func SetClusterInstanceHealthCheck(clusterID, instanceID string, policy *models.HealthCheckPolicy) error {
+	_, err := updateClusterInstance(clusterID, instanceID, func(instance *models.ServerInstance) error {
+		if err := sealPolicy(policy, instance.HealthCheck); err != nil {
+			return err
+		}
+		instance.HealthCheck = policy
+		return nil
+	})
+	return err
+}
+
+// SetClusterInstanceHealthOverride force the state of a instance,
+// nil clears the override
+func SetClusterInstanceHealthOverride(clusterID, instanceID string, override *models.HealthOverride) error {
+	_, err := updateClusterInstance(clusterID, instanceID, func(instance *models.ServerInstance) error {
+		instance.HealthOverride = override
+		return nil
+	})
+	return err
+}
+
+// SetClusterInstanceHealthPause pause probing a instance, nil resumes
+func SetClusterInstanceHealthPause(clusterID, instanceID string, pause *models.HealthPause) error {
+	_, err := updateClusterInstance(clusterID, instanceID, func(instance *models.ServerInstance) error {
+		instance.HealthPause = pause
+		return nil
+	})
+	return err
 }
 
 // GetClusterInstanceInfo load cluster instance from cluster
-func GetClusterInstanceInfo(clusterID, instanceID string) (*models.ServerInstance, error) {
+func GetClusterInstanceInfo(clusterID, instanceID string) (*Instance, error) {
+	instance, err := getClusterInstance(clusterID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	ins := newInstance(instance, getHealthStatus(clusterID, instanceID))
+	if clsOpt, err := getClusterOption(clusterID); err == nil && clsOpt.HealthPause.Active(time.Now()) {
+		ins.Paused = true
+	}
+	return ins, nil
+}
+
+// GetClusterInstanceHealth get the health status of an instance with
+// its transitions, nil means the instance has not been checked
+func GetClusterInstanceHealth(clusterID, instanceID string) (*models.HealthStatus, error) {
+	if _, err := getClusterInstance(clusterID, instanceID); err != nil {
+		return nil, err
+	}
+	return getHealthStatus(clusterID, instanceID), nil
+}
+
+func getClusterInstance(clusterID, instanceID string) (*models.ServerInstance, error) {
 	instance := new(models.ServerInstance)
 	instanceKey := utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
 	v, err := store.Get(instanceKey)
@@ -258,3 +605,246 @@ func GetClusterInstanceInfo(clusterID, instanceID string) (*models.ServerInstanc
 	}
 	return instance, nil
 }
+
+// maxCASRetries is the times to retry a compare-and-swap update
+const maxCASRetries = 3
+
+func instanceKeyOf(clusterID, instanceID string) string {
+	return utils.Fstring("%s%s/%s", configs.ClustersKey, clusterID, instanceID)
+}
+
+// addClusterInstance save a new instance, it fails while the key exists.
+// IsAlive is set to the effective state before the instance is checked
+func addClusterInstance(instance *models.ServerInstance) error {
+	instance.ApplyHealth(nil)
+	data, err := etcdutils.Encode(instance)
+	if err != nil {
+		return err
+	}
+	_, err = store.Kapi.Set(context.Background(), instanceKeyOf(instance.ClusterID, instance.Idx),
+		string(data), &client.SetOptions{PrevExist: client.PrevNoExist})
+	return err
+}
+
+// updateClusterInstance apply update to the stored instance by
+// compare-and-swap, so a concurrent update of the instance, like the health
+// checker mirroring its state, is never overwritten. it's retried while
+// the instance has been updated meanwhile. IsAlive is set to the effective
+// state, so the admin state takes effect in gateways at once.
+func updateClusterInstance(clusterID, instanceID string,
+	update func(instance *models.ServerInstance) error) (*models.ServerInstance, error) {
+	instanceKey := instanceKeyOf(clusterID, instanceID)
+	for retry := 0; retry < maxCASRetries; retry++ {
+		resp, err := store.Kapi.Get(context.Background(), instanceKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		instance := new(models.ServerInstance)
+		if err = etcdutils.Decode(resp.Node.Value, instance); err != nil {
+			return nil, err
+		}
+		if err = update(instance); err != nil {
+			return nil, err
+		}
+		instance.ApplyHealth(getHealthStatus(clusterID, instanceID))
+
+		data, err := etcdutils.Encode(instance)
+		if err != nil {
+			return nil, err
+		}
+		opts := &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex}
+		if _, err = store.Kapi.Set(context.Background(), instanceKey, string(data), opts); err == nil {
+			return instance, nil
+		} else if !isCompareFailed(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("instance %s is updated concurrently, try again", instanceID)
+}
+
+// InstancesDiff is the result of replacing the instance set of a cluster
+type InstancesDiff struct {
+	Added   []*models.ServerInstance `json:"added"`
+	Updated []*models.ServerInstance `json:"updated"`
+	Removed []*models.ServerInstance `json:"removed"`
+	Applied []string                 `json:"applied"` // keys of the instances written or deleted
+}
+
+// ReplaceClusterInstances reconcile the instances of a cluster with the
+// desired list by addr: new addrs are added, existed addrs are updated
+// (keeping their id and health) and missing addrs are removed.
+// the whole diff is computed and checked before any write, the secrets
+// of the health checks are redacted in the diff returned.
+// etcd v2 has no multi-key transaction, so the adds and updates are
+// applied before the deletes, the cluster never loses the instances kept
+// while a write fails halfway. the keys applied are returned with the
+// error then, and replacing with the same list again is safe.
+func ReplaceClusterInstances(clusterID string,
+	desired []*models.ServerInstance) (diff *InstancesDiff, err error) {
+	current, err := getClusterInstances(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentByAddr := make(map[string]*models.ServerInstance, len(current))
+	for _, ins := range current {
+		currentByAddr[ins.Addr] = ins
+	}
+
+	defer func() { diff.redact() }()
+	diff = &InstancesDiff{
+		Added:   make([]*models.ServerInstance, 0),
+		Updated: make([]*models.ServerInstance, 0),
+		Removed: make([]*models.ServerInstance, 0),
+	}
+	seen := make(map[string]bool, len(desired))
+	adminStates := make(map[string]models.AdminState) // set explicitly, by id of the updated
+	for _, want := range desired {
+		if want.Addr == "" {
+			return nil, errors.New("instance addr is required")
+		}
+		if seen[want.Addr] {
+			return nil, fmt.Errorf("duplicate instance addr: %s", want.Addr)
+		}
+		seen[want.Addr] = true
+		if want.AdminState != "" && !want.AdminState.Valid() {
+			return nil, fmt.Errorf("invalid admin state: %s", want.AdminState)
+		}
+
+		old, ok := currentByAddr[want.Addr]
+		if !ok {
+			want.Idx = utils.UUID()
+			want.ClusterID = clusterID
+			want.IsAlive = false
+			if want.AdminState == "" {
+				want.AdminState = models.AdminStateEnabled
+			}
+			if err := secrets.SealPolicy(want.HealthCheck); err != nil {
+				return nil, err
+			}
+			diff.Added = append(diff.Added, want)
+			continue
+		}
+
+		want.HealthCheck.KeepSecrets(old.HealthCheck)
+		if !instanceConfigChanged(old, want) {
+			continue
+		}
+		if err := secrets.SealPolicy(want.HealthCheck); err != nil {
+			return nil, err
+		}
+		old.Name = want.Name
+		old.Weight = want.Weight
+		old.NeedCheckHealth = want.NeedCheckHealth
+		old.HealthCheckURL = want.HealthCheckURL
+		old.HealthCheck = want.HealthCheck
+		if want.AdminState != "" {
+			old.AdminState = want.AdminState
+			adminStates[old.Idx] = want.AdminState
+		}
+		diff.Updated = append(diff.Updated, old)
+	}
+
+	for _, ins := range current {
+		if !seen[ins.Addr] {
+			diff.Removed = append(diff.Removed, ins)
+		}
+	}
+
+	diff.Applied = make([]string, 0, len(diff.Added)+len(diff.Updated)+len(diff.Removed))
+	applied := func(ins *models.ServerInstance) {
+		diff.Applied = append(diff.Applied, instanceKeyOf(clusterID, ins.Idx))
+	}
+	failed := func(err error) error {
+		total := len(diff.Added) + len(diff.Updated) + len(diff.Removed)
+		return fmt.Errorf("%d of %d changes applied: %v", len(diff.Applied), total, err)
+	}
+	for _, ins := range diff.Added {
+		if err := addClusterInstance(ins); err != nil {
+			return diff, failed(err)
+		}
+		applied(ins)
+	}
+	for _, ins := range diff.Updated {
+		updated := ins
+		_, err := updateClusterInstance(clusterID, ins.Idx, func(stored *models.ServerInstance) error {
+			if stored.Addr != updated.Addr {
+				return fmt.Errorf("instance %s has been changed to addr %s", updated.Idx, stored.Addr)
+			}
+			stored.Name = updated.Name
+			stored.Weight = updated.Weight
+			stored.NeedCheckHealth = updated.NeedCheckHealth
+			stored.HealthCheckURL = updated.HealthCheckURL
+			stored.HealthCheck = updated.HealthCheck
+			if state, ok := adminStates[updated.Idx]; ok {
+				stored.AdminState = state
+			}
+			return nil
+		})
+		if err != nil {
+			return diff, failed(err)
+		}
+		applied(ins)
+	}
+	for _, ins := range diff.Removed {
+		if err := DelClusterInstance(clusterID, ins.Idx); err != nil && !isKeyNotFound(err) {
+			return diff, failed(err)
+		}
+		applied(ins)
+	}
+	return diff, nil
+}
+
+// redact the secrets of the health checks, diff could be nil
+func (diff *InstancesDiff) redact() {
+	if diff == nil {
+		return
+	}
+	for _, inses := range [][]*models.ServerInstance{diff.Added, diff.Updated, diff.Removed} {
+		for _, ins := range inses {
+			ins.HealthCheck = ins.HealthCheck.Redacted()
+		}
+	}
+}
+
+// instanceConfigChanged compare the fields which could be replaced
+func instanceConfigChanged(old, want *models.ServerInstance) bool {
+	return old.Name != want.Name ||
+		old.Weight != want.Weight ||
+		old.NeedCheckHealth != want.NeedCheckHealth ||
+		old.HealthCheckURL != want.HealthCheckURL ||
+		!reflect.DeepEqual(old.HealthCheck, want.HealthCheck) ||
+		(want.AdminState != "" && old.GetAdminState() != want.AdminState)
+}
+
+// getClusterInstances load all server instances of a cluster,
+// the cluster must be existed
+func getClusterInstances(clusterID string) ([]*models.ServerInstance, error) {
+	clusterKey := utils.Fstring("%s%s", configs.ClustersKey, clusterID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := store.Kapi.Get(ctx, clusterKey, nil)
+	if err != nil {
+		return nil, err
+	} else if !resp.Node.Dir {
+		return nil, fmt.Errorf("cluster %s is not a dir", clusterID)
+	}
+
+	srvInses := make([]*models.ServerInstance, 0, len(resp.Node.Nodes))
+	for _, srvInsNode := range resp.Node.Nodes {
+		// skip the option node
+		if strings.Split(srvInsNode.Key, "/")[3] == configs.ClusterOptionsKey {
+			continue
+		}
+
+		srvInsCfg := new(models.ServerInstance)
+		if err := etcdutils.Decode(srvInsNode.Value, srvInsCfg); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		srvInses = append(srvInses, srvInsCfg)
+	}
+	return srvInses, nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"path"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+	"go.etcd.io/etcd/client"
+)
+
+// getHealthStatuses load health status of all instances in the cluster,
+// the map key is instanceID
+func getHealthStatuses(clusterID string) map[string]*models.HealthStatus {
+	return loadStatuses(models.HealthKey, clusterID)
+}
+
+// getShadowStatuses load the health status reached in shadow mode of
+// all instances in the cluster, the map key is instanceID
+func getShadowStatuses(clusterID string) map[string]*models.HealthStatus {
+	return loadStatuses(models.ShadowHealthKey, clusterID)
+}
+
+// loadStatuses load the statuses of the cluster under root
+func loadStatuses(root, clusterID string) map[string]*models.HealthStatus {
+	statuses := make(map[string]*models.HealthStatus)
+	clusterHealthKey := utils.Fstring("%s%s", root, clusterID)
+
+	resp, err := store.Kapi.Get(context.Background(), clusterHealthKey, nil)
+	if err != nil {
+		if !isKeyNotFound(err) {
+			logger.Logger.Errorf("loadStatuses(%s, %s) got err: %v", root, clusterID, err)
+		}
+		return statuses
+	}
+
+	for _, node := range resp.Node.Nodes {
+		status := new(models.HealthStatus)
+		if err := etcdutils.Decode(node.Value, status); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		statuses[path.Base(node.Key)] = status
+	}
+	return statuses
+}
+
+// getHealthStatus load health status of an instance, nil means
+// the instance has not been checked yet
+func getHealthStatus(clusterID, instanceID string) *models.HealthStatus {
+	v, err := store.Get(models.HealthStatusKey(clusterID, instanceID))
+	if err != nil {
+		if !isKeyNotFound(err) {
+			logger.Logger.Errorf("getHealthStatus(%s, %s) got err: %v", clusterID, instanceID, err)
+		}
+		return nil
+	}
+
+	status := new(models.HealthStatus)
+	if err := etcdutils.Decode(v, status); err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	return status
+}
+
+// delHealthStatus del the health status and the shadow one of an instance,
+// or the whole cluster while instanceID is empty
+func delHealthStatus(clusterID, instanceID string) {
+	for _, root := range []string{models.HealthKey, models.ShadowHealthKey} {
+		key := utils.Fstring("%s%s", root, clusterID)
+		if instanceID != "" {
+			key = utils.Fstring("%s/%s", key, instanceID)
+		}
+		if err := store.Delete(key, instanceID == ""); err != nil && !isKeyNotFound(err) {
+			logger.Logger.Errorf("delHealthStatus(%s, %s) under %s got err: %v", clusterID, instanceID, root, err)
+		}
+	}
+}
+
+func isKeyNotFound(err error) bool {
+	cErr, ok := err.(client.Error)
+	return ok && cErr.Code == client.ErrorCodeKeyNotFound
+}
+
+func isCompareFailed(err error) bool {
+	cErr, ok := err.(client.Error)
+	return ok && cErr.Code == client.ErrorCodeTestFailed
+}
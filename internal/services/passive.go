@@ -0,0 +1,60 @@
+package services
+
+import (
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// SavePassiveReports save the traffic of instances reported by a gateway
+// into one key of the gateway, the report expires after two windows.
+// the reports of unknown clusters or instances are skipped, like the ones
+// deleted while the gateway has not caught up yet. returns count of saved
+// instance reports and the skipped ones as {clusterID}/{instanceID}.
+func SavePassiveReports(batch *models.PassiveReportBatch) (int, []string, error) {
+	known, skipped, err := knownPassiveInstances(batch.Instances)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := etcdutils.Encode(&models.PassiveReport{
+		Instances: known,
+		Window:    batch.Window,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := store.Set(models.PassiveReportKey(batch.Gateway), data, 2*batch.Window.Std()); err != nil {
+		return 0, nil, err
+	}
+	return len(known), skipped, nil
+}
+
+// knownPassiveInstances split the reports into the ones of existing
+// instances and the keys of the unknown ones
+func knownPassiveInstances(reports []*models.InstanceReport) (known []*models.InstanceReport, skipped []string, err error) {
+	// cluster id to its instance ids, nil for an unknown cluster
+	clusters := make(map[string]map[string]bool)
+	for _, report := range reports {
+		instanceIDs, ok := clusters[report.ClusterID]
+		if !ok {
+			srvInstances, err := getClusterInstances(report.ClusterID)
+			if err != nil && !isKeyNotFound(err) {
+				return nil, nil, err
+			}
+			instanceIDs = make(map[string]bool, len(srvInstances))
+			for _, srvIns := range srvInstances {
+				instanceIDs[srvIns.Idx] = true
+			}
+			clusters[report.ClusterID] = instanceIDs
+		}
+		if !instanceIDs[report.InstanceID] {
+			skipped = append(skipped, report.ClusterID+"/"+report.InstanceID)
+			continue
+		}
+		known = append(known, report)
+	}
+	return known, skipped, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	// "encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/jademperor/common/configs"
 	"github.com/jademperor/common/etcdutils"
@@ -26,6 +27,54 @@ func AddRouting(routing *models.Routing) (string, error) {
 	return routingID, nil
 }
 
+// CloneRouting copy a routing config with a new id,
+// override is called on the copy before saving and could reject it.
+// the copy is rejected while a routing serves its prefix already,
+// so the prefix must be overridden
+func CloneRouting(routingID string, override func(routing *models.Routing) error) (string, error) {
+	routing, err := GetRoutingInfo(routingID)
+	if err != nil {
+		return "", err
+	}
+	if override != nil {
+		if err := override(routing); err != nil {
+			return "", err
+		}
+	}
+
+	dupID, err := routingIDOfPrefix(routing.Prefix)
+	if err != nil {
+		return "", err
+	}
+	if dupID != "" {
+		return "", fmt.Errorf("routing %s serves prefix %s already, override the prefix", dupID, routing.Prefix)
+	}
+	return AddRouting(routing)
+}
+
+// routingIDOfPrefix returns id of the routing serving prefix, empty if none
+func routingIDOfPrefix(prefix string) (string, error) {
+	resp, err := store.Kapi.Get(context.Background(), configs.RoutingsKey, nil)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		routing := new(models.Routing)
+		if err := etcdutils.Decode(node.Value, routing); err != nil {
+			logger.Logger.Errorf("routingIDOfPrefix got err: %v", err)
+			continue
+		}
+		if routing.Prefix == prefix {
+			return routing.Idx, nil
+		}
+	}
+	return "", nil
+}
+
 // DelRouting ...
 func DelRouting(routingID string) error {
 	routingKey := utils.Fstring("%s%s", configs.RoutingsKey, routingID)
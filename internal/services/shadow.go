@@ -0,0 +1,172 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jademperor/common/etcdutils"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// ShadowVerdict compares the state reached in shadow mode with the stored one
+type ShadowVerdict string
+
+const (
+	// VerdictAgree the shadow state is the stored one
+	VerdictAgree ShadowVerdict = "agree"
+	// VerdictWouldMarkDead the stored state is alive but the shadow one is dead
+	VerdictWouldMarkDead ShadowVerdict = "would_mark_dead"
+	// VerdictWouldMarkAlive the stored state is dead but the shadow one is alive
+	VerdictWouldMarkAlive ShadowVerdict = "would_mark_alive"
+	// VerdictPending the instance has not been checked in the shadow mode yet
+	VerdictPending ShadowVerdict = "pending"
+)
+
+// ShadowReport compares the shadow states of the clusters in shadow mode
+// with the stored states
+type ShadowReport struct {
+	Global   *models.ShadowMode     `json:"global,omitempty"`
+	Clusters []*ClusterShadowReport `json:"clusters"`
+}
+
+// ClusterShadowReport is the shadow report of a cluster
+type ClusterShadowReport struct {
+	Idx            string                  `json:"idx"`
+	Name           string                  `json:"name"`
+	Mode           *models.ShadowMode      `json:"mode"` // the cluster's own or the global one
+	Agree          int                     `json:"agree"`
+	WouldMarkDead  int                     `json:"would_mark_dead"`
+	WouldMarkAlive int                     `json:"would_mark_alive"`
+	Pending        int                     `json:"pending"`
+	Instances      []*InstanceShadowReport `json:"instances"`
+}
+
+// InstanceShadowReport is the shadow report of an instance, the states are
+// the effective ones, so an active health override agrees with itself
+type InstanceShadowReport struct {
+	Idx         string               `json:"idx"`
+	Name        string               `json:"name"`
+	Addr        string               `json:"addr"`
+	StoredAlive bool                 `json:"stored_alive"`
+	ShadowAlive bool                 `json:"shadow_alive"`
+	Verdict     ShadowVerdict        `json:"verdict"`
+	Shadow      *models.HealthStatus `json:"shadow,omitempty"` // the status reached in the shadow mode
+}
+
+// GetGlobalShadow load the global shadow mode, nil while not set
+func GetGlobalShadow() (*models.ShadowMode, error) {
+	v, err := store.Get(models.ShadowKey)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	mode := new(models.ShadowMode)
+	if err := etcdutils.Decode(v, mode); err != nil {
+		return nil, err
+	}
+	return mode, nil
+}
+
+// SetGlobalShadow put all clusters in shadow mode, nil leaves it
+func SetGlobalShadow(mode *models.ShadowMode) error {
+	if mode == nil {
+		if err := store.Delete(models.ShadowKey, false); err != nil && !isKeyNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := etcdutils.Encode(mode)
+	if err != nil {
+		return err
+	}
+	return store.Set(models.ShadowKey, string(data), -1)
+}
+
+// GetShadowReport compare the shadow states of the clusters in shadow mode
+// with the stored states, only the cluster of clusterID while it's set
+func GetShadowReport(clusterID string) (*ShadowReport, error) {
+	global, err := GetGlobalShadow()
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := GetAllClusters("")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := &ShadowReport{Global: global, Clusters: make([]*ClusterShadowReport, 0)}
+	for _, cls := range clusters {
+		if clusterID != "" && cls.Idx != clusterID {
+			continue
+		}
+		mode := cls.HealthShadow
+		if !mode.Active(now) {
+			mode = global
+		}
+		if !mode.Active(now) {
+			if clusterID != "" {
+				return nil, fmt.Errorf("cluster %s is not in shadow mode", clusterID)
+			}
+			continue
+		}
+		report.Clusters = append(report.Clusters, newClusterShadowReport(cls, mode, getShadowStatuses(cls.Idx)))
+	}
+	if clusterID != "" && len(report.Clusters) == 0 {
+		return nil, fmt.Errorf("cluster %s not found", clusterID)
+	}
+	return report, nil
+}
+
+// newClusterShadowReport compare the shadow statuses with the instances,
+// the shadow statuses of earlier shadow modes are pending
+func newClusterShadowReport(cls *Cluster, mode *models.ShadowMode,
+	shadows map[string]*models.HealthStatus) *ClusterShadowReport {
+	report := &ClusterShadowReport{
+		Idx:       cls.Idx,
+		Name:      cls.Name,
+		Mode:      mode,
+		Instances: make([]*InstanceShadowReport, 0, len(cls.Instances)),
+	}
+	for _, ins := range cls.Instances {
+		if !ins.NeedCheckHealth {
+			continue
+		}
+		insReport := &InstanceShadowReport{
+			Idx:         ins.Idx,
+			Name:        ins.Name,
+			Addr:        ins.Addr,
+			StoredAlive: ins.IsAlive,
+			Verdict:     VerdictPending,
+		}
+		if shadow := shadows[ins.Idx]; shadow != nil && !shadow.LastCheckTime.Before(mode.Since) {
+			insReport.Shadow = shadow
+			insReport.ShadowAlive = shadow.IsAlive
+			switch {
+			case shadow.IsAlive == ins.IsAlive:
+				insReport.Verdict = VerdictAgree
+			case ins.IsAlive:
+				insReport.Verdict = VerdictWouldMarkDead
+			default:
+				insReport.Verdict = VerdictWouldMarkAlive
+			}
+		}
+
+		switch insReport.Verdict {
+		case VerdictAgree:
+			report.Agree++
+		case VerdictWouldMarkDead:
+			report.WouldMarkDead++
+		case VerdictWouldMarkAlive:
+			report.WouldMarkAlive++
+		default:
+			report.Pending++
+		}
+		report.Instances = append(report.Instances, insReport)
+	}
+	return report
+}
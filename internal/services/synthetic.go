@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+
+	"github.com/jademperor/common/etcdutils"
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/common/pkg/utils"
+	"github.com/jademperor/gateway-manager/internal/logger"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+// Synthetic service layer, the synthetic probe with its health status
+type Synthetic struct {
+	*models.SyntheticProbe
+	TargetURL   string               `json:"target_url,omitempty"`
+	TargetError string               `json:"target_error,omitempty"` // the api or routing is not found, so it's not checked
+	Health      *models.HealthStatus `json:"health,omitempty"`       // nil while it has not been checked
+}
+
+// newSynthetic create the synthetic view, the secrets of the check are
+// redacted. status could be nil
+func newSynthetic(probe *models.SyntheticProbe, status *models.HealthStatus) *Synthetic {
+	synthetic := &Synthetic{SyntheticProbe: probe, Health: status}
+	if targetURL, _, err := probe.Request(lookupTarget(probe)); err != nil {
+		synthetic.TargetError = err.Error()
+	} else {
+		synthetic.TargetURL = targetURL
+	}
+	probe.Check = probe.Check.Redacted()
+	return synthetic
+}
+
+// lookupTarget load the api or routing the probe targets, nil while not found
+func lookupTarget(probe *models.SyntheticProbe) (*cmodels.API, *cmodels.Routing) {
+	if probe.APIID != "" {
+		if api, err := GetAPIInfo(probe.APIID); err == nil {
+			return api, nil
+		}
+		return nil, nil
+	}
+	if probe.RoutingID != "" {
+		if routing, err := GetRoutingInfo(probe.RoutingID); err == nil {
+			return nil, routing
+		}
+	}
+	return nil, nil
+}
+
+// GetAllSynthetics load all synthetic probes with their health status,
+// the transitions are served by GetSynthetic only
+func GetAllSynthetics() ([]*Synthetic, error) {
+	synthetics := make([]*Synthetic, 0)
+	resp, err := store.Kapi.Get(context.Background(), models.SyntheticsKey, nil)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return synthetics, nil
+		}
+		return nil, err
+	}
+
+	for _, node := range resp.Node.Nodes {
+		probe := new(models.SyntheticProbe)
+		if err := etcdutils.Decode(node.Value, probe); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		status := getSyntheticStatus(probe.Idx)
+		if status != nil {
+			brief := *status
+			brief.Transitions = nil
+			status = &brief
+		}
+		synthetics = append(synthetics, newSynthetic(probe, status))
+	}
+	return synthetics, nil
+}
+
+// GetSynthetic load a synthetic probe with its health status and transitions
+func GetSynthetic(probeID string) (*Synthetic, error) {
+	probe, err := getSyntheticProbe(probeID)
+	if err != nil {
+		return nil, err
+	}
+	return newSynthetic(probe, getSyntheticStatus(probeID)), nil
+}
+
+// AddSynthetic add a synthetic probe, the api or routing it targets must exist
+func AddSynthetic(probe *models.SyntheticProbe) (string, error) {
+	probe.Idx = utils.UUID()
+	if err := checkSyntheticTarget(probe); err != nil {
+		return "", err
+	}
+	if err := sealPolicy(probe.Check, nil); err != nil {
+		return "", err
+	}
+	if err := setSyntheticProbe(probe); err != nil {
+		return "", err
+	}
+	return probe.Idx, nil
+}
+
+// UpdateSynthetic replace a synthetic probe, the secrets of the check are
+// kept while they're empty in probe
+func UpdateSynthetic(probe *models.SyntheticProbe) error {
+	old, err := getSyntheticProbe(probe.Idx)
+	if err != nil {
+		return err
+	}
+	if err := checkSyntheticTarget(probe); err != nil {
+		return err
+	}
+	if err := sealPolicy(probe.Check, old.Check); err != nil {
+		return err
+	}
+	return setSyntheticProbe(probe)
+}
+
+// DelSynthetic del a synthetic probe and its health status
+func DelSynthetic(probeID string) error {
+	if err := store.Delete(models.SyntheticKey(probeID), false); err != nil {
+		return err
+	}
+	if err := store.Delete(models.SyntheticStatusKey(probeID), false); err != nil && !isKeyNotFound(err) {
+		logger.Logger.Errorf("DelSynthetic(%s) delete the health status got err: %v", probeID, err)
+	}
+	return nil
+}
+
+// checkSyntheticTarget check the api or routing the probe targets exists
+func checkSyntheticTarget(probe *models.SyntheticProbe) error {
+	_, _, err := probe.Request(lookupTarget(probe))
+	return err
+}
+
+func getSyntheticProbe(probeID string) (*models.SyntheticProbe, error) {
+	v, err := store.Get(models.SyntheticKey(probeID))
+	if err != nil {
+		return nil, err
+	}
+
+	probe := new(models.SyntheticProbe)
+	if err := etcdutils.Decode(v, probe); err != nil {
+		return nil, err
+	}
+	return probe, nil
+}
+
+func setSyntheticProbe(probe *models.SyntheticProbe) error {
+	data, err := etcdutils.Encode(probe)
+	if err != nil {
+		return err
+	}
+	return store.Set(models.SyntheticKey(probe.Idx), string(data), -1)
+}
+
+// getSyntheticStatus load the health status of a synthetic probe, nil
+// means it has not been checked yet
+func getSyntheticStatus(probeID string) *models.HealthStatus {
+	v, err := store.Get(models.SyntheticStatusKey(probeID))
+	if err != nil {
+		if !isKeyNotFound(err) {
+			logger.Logger.Errorf("getSyntheticStatus(%s) got err: %v", probeID, err)
+		}
+		return nil
+	}
+
+	status := new(models.HealthStatus)
+	if err := etcdutils.Decode(v, status); err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	return status
+}
@@ -0,0 +1,531 @@
+// Package validate checks the configs deeper than the binding tags do,
+// every failure is reported with the field it belongs to.
+package validate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+const (
+	// MinWeight of a server instance, there's no upper bound
+	MinWeight = 1
+	// MinInterval of health checks
+	MinInterval = time.Second
+	// MaxHold is the max duration of health overrides and pauses
+	MaxHold = 7 * 24 * time.Hour
+	// MinReportWindow of passive health reports
+	MinReportWindow = time.Second
+	// MaxReportWindow of passive health reports
+	MaxReportWindow = 10 * time.Minute
+)
+
+var (
+	// methods allowed in api configs
+	methods = map[string]bool{
+		"GET":     true,
+		"POST":    true,
+		"PUT":     true,
+		"PATCH":   true,
+		"DELETE":  true,
+		"HEAD":    true,
+		"OPTIONS": true,
+	}
+)
+
+// FieldError is a validation failure of one field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors is a list of FieldError, nil or empty means valid
+type Errors []*FieldError
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for idx, err := range errs {
+		msgs[idx] = err.Error()
+	}
+	return strings.Join(msgs, ";")
+}
+
+func (errs *Errors) add(field, msg string) {
+	*errs = append(*errs, &FieldError{Field: field, Message: msg})
+}
+
+func (errs *Errors) addf(field, format string, v ...interface{}) {
+	errs.add(field, fmt.Sprintf(format, v...))
+}
+
+// merge append errors of a nested config, fields are prefixed
+func (errs *Errors) merge(prefix string, nested Errors) {
+	for _, err := range nested {
+		*errs = append(*errs, &FieldError{Field: prefix + "." + err.Field, Message: err.Message})
+	}
+}
+
+// Instance validate a server instance config
+func Instance(ins *models.ServerInstance) Errors {
+	var errs Errors
+
+	if strings.TrimSpace(ins.Name) == "" {
+		errs.add("name", "is required")
+	}
+	if msg := checkURL(ins.Addr); msg != "" {
+		errs.add("addr", msg)
+	}
+	if ins.Weight < MinWeight {
+		errs.addf("weight", "must be at least %d", MinWeight)
+	}
+	// tcp and grpc checks have no health check url
+	needURL := ins.NeedCheckHealth && (ins.HealthCheck == nil ||
+		ins.HealthCheck.Type == "" || ins.HealthCheck.Type == models.CheckHTTP)
+	if needURL || ins.HealthCheckURL != "" {
+		if msg := checkURL(ins.HealthCheckURL); msg != "" {
+			errs.add("health_check_url", msg)
+		}
+	}
+	if ins.AdminState != "" && !ins.AdminState.Valid() {
+		errs.addf("admin_state", "unknown state %s", ins.AdminState)
+	}
+	if ins.HealthCheck != nil {
+		errs.merge("health_check", HealthCheckPolicy(ins.HealthCheck))
+	}
+
+	return errs
+}
+
+// HealthCheckPolicy validate a health check policy,
+// zero fields are allowed which mean using the defaults
+func HealthCheckPolicy(p *models.HealthCheckPolicy) Errors {
+	var errs Errors
+
+	if p.Type != "" && !p.Type.Valid() {
+		errs.addf("type", "unknown check type %s", p.Type)
+	}
+	if p.Target != "" {
+		if _, port, err := net.SplitHostPort(p.Target); err != nil || port == "" {
+			errs.add("target", "must be host:port")
+		}
+	}
+	if p.Rise < 0 {
+		errs.add("rise", "must not be negative")
+	}
+	if p.Fall < 0 {
+		errs.add("fall", "must not be negative")
+	}
+	if p.Interval != 0 && p.Interval.Std() < MinInterval {
+		errs.addf("interval", "must not be less than %s", MinInterval)
+	}
+	if p.Jitter != models.Disabled && (p.Jitter < 0 || p.Jitter > 1) {
+		errs.add("jitter", "must be between 0 and 1, or -1 to disable")
+	}
+	if p.BackoffFactor != 0 && p.BackoffFactor < 1 {
+		errs.add("backoff_factor", "must not be less than 1")
+	}
+	if p.MaxInterval != 0 && p.MaxInterval.Std() < MinInterval {
+		errs.addf("max_interval", "must not be less than %s", MinInterval)
+	}
+	if p.Interval != 0 && p.MaxInterval != 0 && p.MaxInterval < p.Interval {
+		errs.add("max_interval", "must not be less than interval")
+	}
+	if p.Timeout < 0 {
+		errs.add("timeout", "must not be negative")
+	}
+	if p.Interval != 0 && p.Timeout > p.Interval {
+		errs.add("timeout", "must not be greater than interval")
+	}
+	if p.Method != "" && !methods[p.Method] {
+		errs.addf("method", "unknown method %s", p.Method)
+	}
+	for idx, status := range p.ExpectStatus {
+		if _, _, err := models.ParseStatusRange(status); err != nil {
+			errs.add(fmt.Sprintf("expect_status[%d]", idx), err.Error())
+		}
+	}
+	if p.ExpectBodyRegexp != "" {
+		if _, err := regexp.Compile(p.ExpectBodyRegexp); err != nil {
+			errs.add("expect_body_regexp", err.Error())
+		}
+	}
+	if p.FlapWindow < 0 {
+		errs.add("flap_window", "must not be negative")
+	}
+	if p.FlapThreshold < 0 {
+		errs.add("flap_threshold", "must not be negative")
+	}
+	if p.DegradedFactor != 0 && p.DegradedFactor <= 1 {
+		errs.add("degraded_factor", "must be greater than 1")
+	}
+	if p.DegradedWeight != models.Disabled && (p.DegradedWeight < 0 || p.DegradedWeight > 100) {
+		errs.add("degraded_weight", "must be between 0 and 100, or -1 to disable")
+	}
+	if p.PassiveErrorRate != models.Disabled && (p.PassiveErrorRate < 0 || p.PassiveErrorRate > 1) {
+		errs.add("passive_error_rate", "must be between 0 and 1, or -1 to disable")
+	}
+	if p.PassiveMinReqs < 0 {
+		errs.add("passive_min_requests", "must not be negative")
+	}
+	for name := range p.Headers {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\t\r\n") {
+			errs.addf("headers", "invalid header name %q", name)
+		}
+	}
+	if p.TLS != nil {
+		errs.merge("tls", healthCheckTLS(p.TLS))
+	}
+	if p.Auth != nil {
+		errs.merge("auth", healthCheckAuth(p.Auth))
+	}
+
+	return errs
+}
+
+// ClusterOverrides validate the overrides of a cluster option while
+// cloning it, the nil ones are kept from the original
+func ClusterOverrides(policy *models.HealthCheckPolicy, minHealthy *int) Errors {
+	var errs Errors
+
+	if policy != nil {
+		errs.merge("health_check", HealthCheckPolicy(policy))
+	}
+	if minHealthy != nil && (*minHealthy < 0 || *minHealthy > 100) {
+		errs.add("min_healthy", "must be between 0 and 100")
+	}
+	return errs
+}
+
+// healthCheckTLS validate the PEM of tls options, the key could be
+// empty which keeps the stored one
+func healthCheckTLS(opts *models.HealthCheckTLS) Errors {
+	var errs Errors
+
+	if opts.CA != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(opts.CA)) {
+		errs.add("ca", "no PEM certificate found")
+	}
+	if opts.Cert != "" {
+		if block, _ := pem.Decode([]byte(opts.Cert)); block == nil || block.Type != "CERTIFICATE" {
+			errs.add("cert", "no PEM certificate found")
+		} else if opts.Key != "" {
+			if _, err := tls.X509KeyPair([]byte(opts.Cert), []byte(opts.Key)); err != nil {
+				errs.add("key", err.Error())
+			}
+		}
+	} else if opts.Key != "" {
+		errs.add("key", "needs a cert")
+	}
+
+	return errs
+}
+
+// healthCheckAuth validate the credentials, the secrets could be
+// empty which keeps the stored ones
+func healthCheckAuth(auth *models.HealthCheckAuth) Errors {
+	var errs Errors
+
+	switch auth.Type {
+	case models.AuthBasic:
+		if auth.Username == "" || strings.Contains(auth.Username, ":") {
+			errs.add("username", "is required and must not contain ':'")
+		}
+		if auth.Token != "" {
+			errs.add("token", "is for bearer auth only")
+		}
+	case models.AuthBearer:
+		if auth.Username != "" || auth.Password != "" {
+			errs.add("username", "is for basic auth only")
+		}
+	default:
+		errs.addf("type", "unknown auth type %s", auth.Type)
+	}
+
+	return errs
+}
+
+// SyntheticProbe validate a synthetic probe, the api or routing it
+// targets is checked by the services
+func SyntheticProbe(p *models.SyntheticProbe) Errors {
+	var errs Errors
+
+	if strings.TrimSpace(p.Name) == "" {
+		errs.add("name", "is required")
+	}
+	if msg := checkURL(p.GatewayURL); msg != "" {
+		errs.add("gateway_url", msg)
+	}
+	if (p.APIID == "") == (p.RoutingID == "") {
+		errs.add("api_id", "either api_id or routing_id is required")
+	}
+	// the query is allowed in the path requested
+	if p.Path != "" {
+		if _, err := url.ParseRequestURI(p.Path); err != nil || !strings.HasPrefix(p.Path, "/") ||
+			strings.ContainsAny(p.Path, " \t\r\n#") {
+			errs.add("path", "must start with / and not contain whitespace or #")
+		}
+	}
+	if p.Check != nil {
+		if p.Check.Type != "" && p.Check.Type != models.CheckHTTP {
+			errs.add("check.type", "must be http")
+		}
+		errs.merge("check", HealthCheckPolicy(p.Check))
+	}
+
+	return errs
+}
+
+// Hold validate the duration of a health override or pause
+func Hold(d models.Duration) Errors {
+	var errs Errors
+	if d <= 0 {
+		errs.add("duration", "must be positive")
+	}
+	if d.Std() > MaxHold {
+		errs.addf("duration", "must not be greater than %s", MaxHold)
+	}
+	return errs
+}
+
+// Instances validate a list of server instance configs,
+// fields are prefixed with "instances[idx]"
+func Instances(inses []*models.ServerInstance) Errors {
+	var errs Errors
+	for idx, ins := range inses {
+		if ins == nil {
+			errs.add(fmt.Sprintf("instances[%d]", idx), "is null")
+			continue
+		}
+		errs.merge(fmt.Sprintf("instances[%d]", idx), Instance(ins))
+	}
+	return errs
+}
+
+// API validate an api config
+func API(api *cmodels.API) Errors {
+	var errs Errors
+
+	if msg := checkPath(api.Path); msg != "" {
+		errs.add("path", msg)
+	}
+	if !methods[api.Method] {
+		errs.addf("method", "unknown method %s", api.Method)
+	}
+	if api.RewritePath != "" {
+		if msg := checkPath(api.RewritePath); msg != "" {
+			errs.add("rewrite_path", msg)
+		}
+	}
+
+	if api.NeedCombine {
+		if len(api.CombineReqCfgs) == 0 {
+			errs.add("combinations", "is required while need_combine is true")
+		}
+	} else {
+		if len(api.CombineReqCfgs) != 0 {
+			errs.add("combinations", "must be empty while need_combine is false")
+		}
+		if api.TargetClusterID == "" {
+			errs.add("target_cluster_id", "is required while need_combine is false")
+		}
+	}
+
+	fields := make(map[string]bool, len(api.CombineReqCfgs))
+	for idx, comb := range api.CombineReqCfgs {
+		prefix := fmt.Sprintf("combinations[%d]", idx)
+		if comb == nil {
+			errs.add(prefix, "is null")
+			continue
+		}
+		errs.merge(prefix, combination(comb))
+		if fields[comb.Field] {
+			errs.addf(prefix+".field", "duplicate field %s", comb.Field)
+		}
+		fields[comb.Field] = true
+	}
+
+	return errs
+}
+
+func combination(comb *cmodels.APICombination) Errors {
+	var errs Errors
+
+	if msg := checkPath(comb.Path); msg != "" {
+		errs.add("path", msg)
+	}
+	if strings.TrimSpace(comb.Field) == "" {
+		errs.add("field", "is required")
+	}
+	if !methods[comb.Method] {
+		errs.addf("method", "unknown method %s", comb.Method)
+	}
+	if comb.TargetClusterID == "" {
+		errs.add("target_cluster_id", "is required")
+	}
+
+	return errs
+}
+
+// checkURL returns the reason why s is not an absolute http(s) URL
+func checkURL(s string) string {
+	if s == "" {
+		return "is required"
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "invalid url: " + err.Error()
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "scheme must be http or https"
+	}
+	if u.Host == "" {
+		return "host is required"
+	}
+	return ""
+}
+
+// checkPath returns the reason why s is not a valid request path
+func checkPath(s string) string {
+	if s == "" {
+		return "is required"
+	}
+	if !strings.HasPrefix(s, "/") {
+		return "must start with /"
+	}
+	if strings.ContainsAny(s, " \t\r\n?#") {
+		return "must not contain whitespace, ? or #"
+	}
+	if _, err := url.ParseRequestURI(s); err != nil {
+		return "invalid path: " + err.Error()
+	}
+	return ""
+}
+
+// AlertingConfig validate the alerting rules and channels, the channels
+// notified by rules must exist
+func AlertingConfig(cfg *models.AlertingConfig) Errors {
+	var errs Errors
+
+	names := make(map[string]bool, len(cfg.Channels))
+	for idx, ch := range cfg.Channels {
+		field := fmt.Sprintf("channels[%d]", idx)
+		if ch == nil {
+			errs.add(field, "is null")
+			continue
+		}
+		if strings.TrimSpace(ch.Name) == "" {
+			errs.add(field+".name", "is required")
+		} else if names[ch.Name] {
+			errs.addf(field+".name", "duplicate channel %s", ch.Name)
+		}
+		names[ch.Name] = true
+
+		switch ch.Type {
+		case models.ChannelWebhook:
+			if ch.Webhook == nil {
+				errs.add(field+".webhook", "is required")
+			} else if msg := checkURL(ch.Webhook.URL); msg != "" {
+				errs.add(field+".webhook.url", msg)
+			}
+		case models.ChannelSMTP:
+			if ch.SMTP == nil {
+				errs.add(field+".smtp", "is required")
+				continue
+			}
+			if _, port, err := net.SplitHostPort(ch.SMTP.Addr); err != nil || port == "" {
+				errs.add(field+".smtp.addr", "must be host:port")
+			}
+			if strings.TrimSpace(ch.SMTP.From) == "" {
+				errs.add(field+".smtp.from", "is required")
+			}
+			if len(ch.SMTP.To) == 0 {
+				errs.add(field+".smtp.to", "is required")
+			}
+		default:
+			errs.addf(field+".type", "unknown channel type %s", ch.Type)
+		}
+	}
+
+	ids := make(map[string]bool, len(cfg.Rules))
+	for idx, rule := range cfg.Rules {
+		field := fmt.Sprintf("rules[%d]", idx)
+		if rule == nil {
+			errs.add(field, "is null")
+			continue
+		}
+		if strings.TrimSpace(rule.ID) == "" {
+			errs.add(field+".id", "is required")
+		} else if ids[rule.ID] {
+			errs.addf(field+".id", "duplicate rule %s", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if !rule.Kind.Valid() {
+			errs.addf(field+".kind", "unknown alert kind %s", rule.Kind)
+		}
+		if rule.Kind == models.AlertSyntheticFailing && rule.ClusterID != "" {
+			errs.add(field+".cluster_id", "must be empty for synthetic probes")
+		}
+		if rule.Kind != models.AlertSyntheticFailing && rule.ProbeID != "" {
+			errs.addf(field+".probe_id", "is for %s only", models.AlertSyntheticFailing)
+		}
+		if rule.For < 0 {
+			errs.add(field+".for", "must not be negative")
+		}
+		if rule.RepeatInterval < 0 {
+			errs.add(field+".repeat_interval", "must not be negative")
+		}
+		if len(rule.Channels) == 0 {
+			errs.add(field+".channels", "is required")
+		}
+		for _, name := range rule.Channels {
+			if !names[name] {
+				errs.addf(field+".channels", "unknown channel %s", name)
+			}
+		}
+	}
+
+	return errs
+}
+
+// PassiveReportBatch validate the passive health reports of a gateway
+func PassiveReportBatch(batch *models.PassiveReportBatch) Errors {
+	var errs Errors
+
+	if strings.TrimSpace(batch.Gateway) == "" || strings.Contains(batch.Gateway, "/") {
+		errs.add("gateway", "is required and must not contain /")
+	}
+	if batch.Window.Std() < MinReportWindow || batch.Window.Std() > MaxReportWindow {
+		errs.addf("window", "must be in range [%s, %s]", MinReportWindow, MaxReportWindow)
+	}
+	for idx, report := range batch.Instances {
+		field := fmt.Sprintf("instances[%d]", idx)
+		if report == nil {
+			errs.add(field, "is null")
+			continue
+		}
+		if report.ClusterID == "" || strings.Contains(report.ClusterID, "/") {
+			errs.add(field+".cluster_id", "is required and must not contain /")
+		}
+		if report.InstanceID == "" || strings.Contains(report.InstanceID, "/") {
+			errs.add(field+".instance_id", "is required and must not contain /")
+		}
+		if report.Successes < 0 || report.Errors < 0 {
+			errs.add(field, "counts must not be negative")
+		}
+	}
+	return errs
+}
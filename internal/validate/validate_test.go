@@ -0,0 +1,238 @@
+package validate
+
+import (
+	"testing"
+
+	cmodels "github.com/jademperor/common/models"
+	"github.com/jademperor/gateway-manager/internal/models"
+)
+
+func fields(errs Errors) map[string]bool {
+	m := make(map[string]bool, len(errs))
+	for _, err := range errs {
+		m[err.Field] = true
+	}
+	return m
+}
+
+func Test_Instance(t *testing.T) {
+	cases := []struct {
+		name   string
+		ins    cmodels.ServerInstance
+		fields []string
+	}{
+		{
+			name: "valid",
+			ins: cmodels.ServerInstance{Name: "a", Addr: "http://127.0.0.1:8080", Weight: 5,
+				NeedCheckHealth: true, HealthCheckURL: "http://127.0.0.1:8080/health"},
+		},
+		{
+			name:   "bad addr",
+			ins:    cmodels.ServerInstance{Name: "a", Addr: "http:/foo", Weight: 5},
+			fields: []string{"addr"},
+		},
+		{
+			name: "health url without scheme",
+			ins: cmodels.ServerInstance{Name: "a", Addr: "http://foo", Weight: 5,
+				NeedCheckHealth: true, HealthCheckURL: "foo/health"},
+			fields: []string{"health_check_url"},
+		},
+		{
+			name:   "negative weight",
+			ins:    cmodels.ServerInstance{Name: "a", Addr: "https://foo", Weight: -1},
+			fields: []string{"weight"},
+		},
+		{
+			name: "large weight",
+			ins:  cmodels.ServerInstance{Name: "a", Addr: "https://foo", Weight: 1000},
+		},
+	}
+
+	for _, c := range cases {
+		errs := Instance(&models.ServerInstance{ServerInstance: c.ins})
+		if len(errs) != len(c.fields) {
+			t.Errorf("%s: want %d errors, got: %v", c.name, len(c.fields), errs)
+			continue
+		}
+		got := fields(errs)
+		for _, f := range c.fields {
+			if !got[f] {
+				t.Errorf("%s: want error on %s, got: %v", c.name, f, errs)
+			}
+		}
+	}
+}
+
+func Test_API(t *testing.T) {
+	comb := func(field string) *cmodels.APICombination {
+		return &cmodels.APICombination{Path: "/a", Field: field, Method: "GET", TargetClusterID: "c"}
+	}
+	cases := []struct {
+		name   string
+		api    cmodels.API
+		fields []string
+	}{
+		{
+			name: "valid",
+			api:  cmodels.API{Path: "/a", Method: "GET", TargetClusterID: "c", RewritePath: "/b"},
+		},
+		{
+			name:   "bad method and path",
+			api:    cmodels.API{Path: "a", Method: "FETCH", TargetClusterID: "c"},
+			fields: []string{"path", "method"},
+		},
+		{
+			name:   "bad rewrite path",
+			api:    cmodels.API{Path: "/a", Method: "GET", TargetClusterID: "c", RewritePath: "b"},
+			fields: []string{"rewrite_path"},
+		},
+		{
+			name: "duplicate field",
+			api: cmodels.API{Path: "/a", Method: "GET", NeedCombine: true,
+				CombineReqCfgs: []*cmodels.APICombination{comb("x"), comb("x")}},
+			fields: []string{"combinations[1].field"},
+		},
+		{
+			name:   "need combine without combinations",
+			api:    cmodels.API{Path: "/a", Method: "GET", NeedCombine: true},
+			fields: []string{"combinations"},
+		},
+		{
+			name: "combinations without need combine",
+			api: cmodels.API{Path: "/a", Method: "GET", TargetClusterID: "c",
+				CombineReqCfgs: []*cmodels.APICombination{comb("x")}},
+			fields: []string{"combinations"},
+		},
+	}
+
+	for _, c := range cases {
+		errs := API(&c.api)
+		if len(errs) != len(c.fields) {
+			t.Errorf("%s: want %d errors, got: %v", c.name, len(c.fields), errs)
+			continue
+		}
+		got := fields(errs)
+		for _, f := range c.fields {
+			if !got[f] {
+				t.Errorf("%s: want error on %s, got: %v", c.name, f, errs)
+			}
+		}
+	}
+}
+
+func Test_AlertingConfig(t *testing.T) {
+	cfg := &models.AlertingConfig{
+		Rules: []*models.AlertRule{
+			{ID: "down", Kind: models.AlertInstanceDown, Channels: []string{"hook"}},
+			{ID: "down", Kind: "unknown", Channels: []string{"mail"}},
+		},
+		Channels: []*models.AlertChannel{
+			{Name: "hook", Type: models.ChannelWebhook, Webhook: &models.WebhookChannel{URL: "http://127.0.0.1/alerts"}},
+			{Name: "smtp", Type: models.ChannelSMTP, SMTP: &models.SMTPChannel{Addr: "127.0.0.1", From: "a@b.c"}},
+		},
+	}
+	got := fields(AlertingConfig(cfg))
+	for _, field := range []string{"rules[1].id", "rules[1].kind", "rules[1].channels", "channels[1].smtp.addr", "channels[1].smtp.to"} {
+		if !got[field] {
+			t.Errorf("want error of %s, got: %v", field, got)
+		}
+	}
+	if len(got) != 5 {
+		t.Errorf("want 5 errors, got: %v", got)
+	}
+}
+
+func Test_HealthCheckPolicyCredentials(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy models.HealthCheckPolicy
+		fields []string
+	}{
+		{
+			name:   "basic auth",
+			policy: models.HealthCheckPolicy{Auth: &models.HealthCheckAuth{Type: models.AuthBasic, Username: "u", Password: "p"}},
+		},
+		{
+			name:   "bearer with username",
+			policy: models.HealthCheckPolicy{Auth: &models.HealthCheckAuth{Type: models.AuthBearer, Username: "u"}},
+			fields: []string{"auth.username"},
+		},
+		{
+			name:   "unknown auth",
+			policy: models.HealthCheckPolicy{Auth: &models.HealthCheckAuth{Type: "digest"}},
+			fields: []string{"auth.type"},
+		},
+		{
+			name:   "bad ca and key without cert",
+			policy: models.HealthCheckPolicy{TLS: &models.HealthCheckTLS{CA: "ca", Key: "key"}},
+			fields: []string{"tls.ca", "tls.key"},
+		},
+		{
+			name:   "bad cert",
+			policy: models.HealthCheckPolicy{TLS: &models.HealthCheckTLS{Cert: "cert", InsecureSkipVerify: true}},
+			fields: []string{"tls.cert"},
+		},
+	}
+
+	for _, c := range cases {
+		errs := HealthCheckPolicy(&c.policy)
+		if len(errs) != len(c.fields) {
+			t.Errorf("%s: want %d errors, got: %v", c.name, len(c.fields), errs)
+			continue
+		}
+		got := fields(errs)
+		for _, f := range c.fields {
+			if !got[f] {
+				t.Errorf("%s: want error on %s, got: %v", c.name, f, errs)
+			}
+		}
+	}
+}
+
+func Test_SyntheticProbe(t *testing.T) {
+	cases := []struct {
+		name   string
+		probe  models.SyntheticProbe
+		fields []string
+	}{
+		{
+			name:  "api with query",
+			probe: models.SyntheticProbe{Name: "p", GatewayURL: "http://gw:8080", APIID: "a1", Path: "/users/1?full=1"},
+		},
+		{
+			name:   "both targets",
+			probe:  models.SyntheticProbe{Name: "p", GatewayURL: "http://gw:8080", APIID: "a1", RoutingID: "r1"},
+			fields: []string{"api_id"},
+		},
+		{
+			name:   "no name, bad path and tcp check",
+			probe:  models.SyntheticProbe{GatewayURL: "http://gw:8080", RoutingID: "r1", Path: "ping", Check: &models.HealthCheckPolicy{Type: models.CheckTCP}},
+			fields: []string{"name", "path", "check.type"},
+		},
+	}
+
+	for _, c := range cases {
+		errs := SyntheticProbe(&c.probe)
+		if len(errs) != len(c.fields) {
+			t.Errorf("%s: want %d errors, got: %v", c.name, len(c.fields), errs)
+			continue
+		}
+		got := fields(errs)
+		for _, f := range c.fields {
+			if !got[f] {
+				t.Errorf("%s: want error on %s, got: %v", c.name, f, errs)
+			}
+		}
+	}
+}
+
+func Test_ClusterOverrides(t *testing.T) {
+	if errs := ClusterOverrides(nil, nil); len(errs) != 0 {
+		t.Errorf("want no overrides valid, got: %v", errs)
+	}
+	minHealthy := 101
+	got := fields(ClusterOverrides(&models.HealthCheckPolicy{Rise: -1}, &minHealthy))
+	if len(got) != 2 || !got["health_check.rise"] || !got["min_healthy"] {
+		t.Errorf("want errors on health_check.rise and min_healthy, got: %v", got)
+	}
+}